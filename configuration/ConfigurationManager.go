@@ -52,6 +52,13 @@ func (cm *ConfigurationManager) LoadConfig() error {
 		return err
 	}
 
+	if cm.Config.SecretsPath != "" {
+		if err = cm.loadJson(cm.Config.SecretsPath, &cm.Config.Secrets); err != nil {
+			log.Error("Error cargando secretos:", err)
+			return err
+		}
+	}
+
 	for idx, network := range cm.Config.Networks {
 		// resources
 		if network.ResourcesPath != "" {
@@ -69,6 +69,16 @@ type Network struct {
 	Peers              map[peer.ID]PeerType
 	DHT                *dht.IpfsDHT
 	NetworkMemberTopic *pubsub.Topic
+	Breakers           map[string]*CircuitBreaker
+	mutexBreakers      sync.Mutex
+	Caches             map[string]*ResponseCache
+	mutexCaches        sync.Mutex
+	cursores           map[string]*cursorConsulta
+	cursoresPorPeer    map[peer.ID]int
+	mutexCursores      sync.Mutex
+	ResultCaches       map[string]*ResultCache
+	mutexResultCaches  sync.Mutex
+	FileCache          *FileCache
 }
 
 type PeerType struct {
@@ -93,6 +103,11 @@ func NewNetwork(name string, port string, swarmKey string, pivots []string, addr
 		MutexSesiones:   sync.RWMutex{},
 		MasterEntities:  map[string]crypto.PubKey{},
 		Peers:           map[peer.ID]PeerType{},
+		Breakers:        map[string]*CircuitBreaker{},
+		Caches:          map[string]*ResponseCache{},
+		cursores:        map[string]*cursorConsulta{},
+		cursoresPorPeer: map[peer.ID]int{},
+		ResultCaches:    map[string]*ResultCache{},
 	}
 
 	return &N
@@ -146,6 +161,7 @@ func (n *Network) Connect() {
 		fmt.Printf("👉 %s/p2p/%s\n", addr, peerID)
 	}
 	go n.MonitorConnections(priv)
+	n.publicarInstantaneas()
 	n.initDHT()
 
 	// Protocolos de funcionamiento de la red
@@ -153,10 +169,20 @@ func (n *Network) Connect() {
 	n.Host.SetStreamHandler(global.ProtocolJoin, n.handleJoinStream)
 	// Protocolos de comunicación
 	n.Host.SetStreamHandler(global.ProtocolAPIProxy, n.handleAPIProxyStream)
+	n.Host.SetStreamHandler(global.ProtocolAPICatalog, n.handleAPICatalogStream)
 	n.Host.SetStreamHandler(global.ProtocolFileSystem, n.handleFileFetch)
 	n.Host.SetStreamHandler(global.ProtocolFileSystemStat, n.handleFileStat)
+	n.Host.SetStreamHandler(global.ProtocolFileSystemRange, n.handleFileRange)
+	n.Host.SetStreamHandler(global.ProtocolFileSystemStatX, n.handleFileStatX)
+	n.Host.SetStreamHandler(global.ProtocolFileSystemList, n.handleFileList)
 	n.Host.SetStreamHandler(global.ProtocolQuery, n.HandleSearch)
+	n.Host.SetStreamHandler(global.ProtocolQuerySchema, n.handleQuerySchema)
+	n.Host.SetStreamHandler(global.ProtocolQuerySubscribe, n.handleQuerySubscribe)
+	n.Host.SetStreamHandler(global.ProtocolTCPTunnel, n.handleTCPTunnelStream)
+	n.Host.SetStreamHandler(global.ProtocolGRPCProxy, n.handleGRPCProxyStream)
 	go n.FileSystem()
+	go n.TCPTunnels()
+	go n.Instantaneas()
 	go n.InitBroadcast()
 
 	fmt.Println("\nServidor esperando conexiones...")
@@ -73,6 +73,14 @@ func (nm *NetworkManager) AddNetwork(network global.NetworkType) {
 		network.Resources,
 		network.RemoteResources,
 	)
+	if network.FSCache != nil {
+		cache, err := NewFileCache(network.FSCache)
+		if err != nil {
+			log.Error("Error al abrir la caché de archivos: ", err)
+			return
+		}
+		nm.Networks[network.Name].FileCache = cache
+	}
 }
 
 func (nm *NetworkManager) GetNetwork(name string) (*Network, bool) {
@@ -0,0 +1,132 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// escritorLotes junta lo que escribe el writer IPC y lo envía como una sola
+// trama en cada enviar. La concatenación de las tramas es un stream Arrow IPC
+// válido.
+type escritorLotes struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (e *escritorLotes) Write(p []byte) (int, error) {
+	return e.buf.Write(p)
+}
+
+func (e *escritorLotes) enviar() error {
+	if e.buf.Len() == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf.Bytes())
+	e.buf.Reset()
+	return err
+}
+
+// conexionFija devuelve la conexión sobre la que leer con la API Arrow: la
+// misma si db ya es una, o una tomada de la base hasta llamar a liberar.
+func conexionFija(ctx context.Context, db ejecutorSQL) (*sql.Conn, func() error, error) {
+	if conn, ok := db.(*sql.Conn); ok {
+		return conn, func() error { return nil }, nil
+	}
+	conn, err := db.(*sql.DB).Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.Close, nil
+}
+
+// enviarArrow ejecuta la consulta y envía el resultado en formato Arrow IPC,
+// en lotes de a lo más bloque filas, sin pasar de maxFilas si es mayor que
+// cero. La API Arrow de DuckDB no enlaza
+// parámetros con nombre, así que las plantillas se materializan antes en una
+// tabla temporal de la misma conexión.
+func enviarArrow(ctx context.Context, db ejecutorSQL, w io.Writer, query string, args []interface{}, bloque int, maxFilas int64) (int64, error) {
+	conn, liberar, err := conexionFija(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer liberar()
+
+	if len(args) > 0 {
+		if _, err := conn.ExecContext(ctx, "CREATE OR REPLACE TEMP TABLE resultado AS "+query+"\n", args...); err != nil {
+			return 0, err
+		}
+		query = "SELECT * FROM resultado"
+	}
+
+	var filas int64
+	err = conn.Raw(func(dc interface{}) error {
+		a, err := duckdb.NewArrowFromConn(dc.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		rr, err := a.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rr.Release()
+
+		salida := &escritorLotes{w: w}
+		iw := ipc.NewWriter(salida, ipc.WithSchema(rr.Schema()))
+		for rr.Next() {
+			rec := rr.Record()
+			for desde := int64(0); desde < rec.NumRows(); desde += int64(bloque) {
+				hasta := min(desde+int64(bloque), rec.NumRows())
+				if maxFilas > 0 && filas+hasta-desde > maxFilas {
+					return excesoFilas(maxFilas)
+				}
+				lote := rec.NewSlice(desde, hasta)
+				err := iw.Write(lote)
+				lote.Release()
+				if err != nil {
+					return err
+				}
+				if err := salida.enviar(); err != nil {
+					return err
+				}
+				filas += hasta - desde
+			}
+		}
+		if err := rr.Err(); err != nil {
+			return err
+		}
+		if err := iw.Close(); err != nil {
+			return err
+		}
+		return salida.enviar()
+	})
+	return filas, err
+}
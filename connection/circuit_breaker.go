@@ -0,0 +1,227 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"errors"
+	"sync"
+	"time"
+
+	global "Veredarii/global"
+)
+
+const (
+	circuitoCerrado     = "closed"
+	circuitoAbierto     = "open"
+	circuitoSemiabierto = "half-open"
+
+	timeoutProxyDefecto   = 30 * time.Second
+	fallosBreakerDefecto  = 5
+	esperaBreakerDefecto  = 30 * time.Second
+	concurrenciaIlimitada = 0
+)
+
+var (
+	errCircuitoAbierto = errors.New("circuito abierto")
+	errSinCupo         = errors.New("límite de llamadas concurrentes alcanzado")
+)
+
+// CircuitBreaker protege a un servicio publicado: limita las llamadas en
+// curso (bulkhead) y, tras varios fallos seguidos, rechaza de inmediato hasta
+// que pasa el tiempo de espera y una llamada de prueba vuelve a funcionar.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	servicio string
+	limites  global.ProxyLimitsType
+	estado   string
+	fallos   int
+	abierto  time.Time
+	prueba   bool
+	umbral   int
+	espera   time.Duration
+	timeout  time.Duration
+	cupos    chan struct{}
+	enCurso  int
+	rechazos int64
+}
+
+type BreakerStatus struct {
+	Service       string `json:"service"`
+	State         string `json:"state"`
+	Failures      int    `json:"consecutive_failures"`
+	InFlight      int    `json:"in_flight"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	Rejected      int64  `json:"rejected"`
+	RetryAfter    int    `json:"retry_after_seconds,omitempty"`
+}
+
+func NewCircuitBreaker(servicio string, limites *global.ProxyLimitsType) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		servicio: servicio,
+		estado:   circuitoCerrado,
+		umbral:   fallosBreakerDefecto,
+		espera:   esperaBreakerDefecto,
+		timeout:  timeoutProxyDefecto,
+	}
+	if limites == nil {
+		return cb
+	}
+	cb.limites = *limites
+	if limites.BreakerFailures > 0 {
+		cb.umbral = limites.BreakerFailures
+	}
+	if limites.BreakerCooldownSeconds > 0 {
+		cb.espera = time.Duration(limites.BreakerCooldownSeconds) * time.Second
+	}
+	if limites.TimeoutSeconds > 0 {
+		cb.timeout = time.Duration(limites.TimeoutSeconds) * time.Second
+	}
+	if limites.MaxConcurrent > concurrenciaIlimitada {
+		cb.cupos = make(chan struct{}, limites.MaxConcurrent)
+	}
+	return cb
+}
+
+// Entrar reserva un cupo para la llamada. Si devuelve nil, hay que llamar a
+// Salir con el resultado cuando la llamada termine.
+func (cb *CircuitBreaker) Entrar() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.estado {
+	case circuitoAbierto:
+		if time.Since(cb.abierto) < cb.espera {
+			cb.rechazos++
+			return errCircuitoAbierto
+		}
+		cb.estado = circuitoSemiabierto
+		cb.prueba = false
+		fallthrough
+	case circuitoSemiabierto:
+		if cb.prueba {
+			cb.rechazos++
+			return errCircuitoAbierto
+		}
+		cb.prueba = true
+	}
+
+	if cb.cupos != nil {
+		select {
+		case cb.cupos <- struct{}{}:
+		default:
+			if cb.estado == circuitoSemiabierto {
+				cb.prueba = false
+			}
+			cb.rechazos++
+			return errSinCupo
+		}
+	}
+	cb.enCurso++
+	return nil
+}
+
+func (cb *CircuitBreaker) Salir(exito bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.cupos != nil {
+		<-cb.cupos
+	}
+	cb.enCurso--
+
+	if exito {
+		cb.fallos = 0
+		cb.estado = circuitoCerrado
+		cb.prueba = false
+		return
+	}
+
+	cb.fallos++
+	if cb.estado == circuitoSemiabierto || cb.fallos >= cb.umbral {
+		cb.estado = circuitoAbierto
+		cb.abierto = time.Now()
+		cb.prueba = false
+	}
+}
+
+func (cb *CircuitBreaker) Timeout() time.Duration {
+	return cb.timeout
+}
+
+// ReintentarEn indica cuánto falta para que el circuito acepte una prueba.
+func (cb *CircuitBreaker) ReintentarEn() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.estado != circuitoAbierto {
+		return time.Second
+	}
+	return cb.espera - time.Since(cb.abierto)
+}
+
+func (cb *CircuitBreaker) Estado() BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	st := BreakerStatus{
+		Service:       cb.servicio,
+		State:         cb.estado,
+		Failures:      cb.fallos,
+		InFlight:      cb.enCurso,
+		MaxConcurrent: cap(cb.cupos),
+		Rejected:      cb.rechazos,
+	}
+	if cb.estado == circuitoAbierto {
+		if falta := cb.espera - time.Since(cb.abierto); falta > 0 {
+			st.RetryAfter = int(falta.Seconds()) + 1
+		}
+	}
+	return st
+}
+
+// breaker devuelve el circuito del servicio publicado, creándolo con los
+// límites del recurso la primera vez. Si los límites cambiaron se crea uno
+// nuevo; las llamadas en curso terminan sobre el anterior.
+func (n *Network) breaker(resource global.ResourceType) *CircuitBreaker {
+	n.mutexBreakers.Lock()
+	defer n.mutexBreakers.Unlock()
+	var limites global.ProxyLimitsType
+	if resource.Proxy != nil {
+		limites = *resource.Proxy
+	}
+	cb, ok := n.Breakers[resource.Name]
+	if !ok || cb.limites != limites {
+		cb = NewCircuitBreaker(resource.Name, resource.Proxy)
+		n.Breakers[resource.Name] = cb
+	}
+	return cb
+}
+
+func (n *Network) EstadoBreakers() []BreakerStatus {
+	n.mutexBreakers.Lock()
+	defer n.mutexBreakers.Unlock()
+	estados := make([]BreakerStatus, 0, len(n.Breakers))
+	for _, cb := range n.Breakers {
+		estados = append(estados, cb.Estado())
+	}
+	return estados
+}
@@ -0,0 +1,25 @@
+package connection
+
+import (
+	"testing"
+
+	global "Veredarii/global"
+)
+
+func TestBreakerCambioLimites(t *testing.T) {
+	n := NewNetwork("red", "0", "", nil, nil, nil, nil, global.ResourcesType{}, global.ResourcesType{})
+	recurso := global.ResourceType{Name: "api", Proxy: &global.ProxyLimitsType{MaxConcurrent: 1}}
+
+	cb := n.breaker(recurso)
+	if otro := n.breaker(recurso); otro != cb {
+		t.Error("con los mismos límites se reutiliza el circuito")
+	}
+	recurso.Proxy = &global.ProxyLimitsType{MaxConcurrent: 2}
+	if nuevo := n.breaker(recurso); nuevo == cb || nuevo.Estado().MaxConcurrent != 2 {
+		t.Errorf("al cambiar los límites se crea otro circuito: %+v", nuevo.Estado())
+	}
+	recurso.Proxy = nil
+	if nuevo := n.breaker(recurso); nuevo.Estado().MaxConcurrent != 0 || nuevo.Timeout() != timeoutProxyDefecto {
+		t.Errorf("sin límites se usan los valores por defecto: %+v", nuevo.Estado())
+	}
+}
@@ -0,0 +1,209 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"Veredarii/configuration"
+	global "Veredarii/global"
+)
+
+// catalogoFuente es el nombre con el que se adjuntan las bases sqlite,
+// postgres y mysql. La consulta del cliente no lo puede nombrar porque el
+// sandbox rechaza las tablas calificadas.
+const (
+	catalogoFuente = "fuente"
+	secretoFuente  = "secreto_fuente"
+)
+
+// Extensiones de DuckDB que necesita cada tipo de DATA_SOURCE.
+var extensionesFuente = map[string]string{
+	global.ResourceTypeSQLite:   "sqlite",
+	global.ResourceTypePostgres: "postgres",
+	global.ResourceTypeMySQL:    "mysql",
+	global.ResourceTypeS3:       "httpfs",
+	global.ResourceTypeHTTP:     "httpfs",
+}
+
+var opcionSecreto = regexp.MustCompile(`^[a-z_]+$`)
+
+// prepararOrigen deja la fuente de datos disponible en db y devuelve con qué
+// se reemplaza {{ORIGIN}} y qué vistas y archivos puede leer la consulta. Los
+// archivos se entregan por su ruta; las bases de datos y las políticas, como
+// la vista origen.
+func prepararOrigen(db *sql.DB, ds global.ResourceType, p *global.DataPolicyType) (string, []string, []string, error) {
+	relacion, err := conectarFuente(db, ds)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if p != nil {
+		var clave []byte
+		if len(p.Hash) > 0 {
+			if clave, err = claveEnmascarado(ds); err != nil {
+				return "", nil, nil, err
+			}
+		}
+		if err := crearVistaPolitica(db, relacion, p, clave); err != nil {
+			return "", nil, nil, err
+		}
+		return vistaOrigen, []string{vistaOrigen}, nil, nil
+	}
+	if archivo := archivoFuente(ds); archivo != "" {
+		return archivo, nil, []string{archivo}, nil
+	}
+	if _, err := db.Exec("CREATE VIEW " + vistaOrigen + " AS SELECT * FROM " + relacion); err != nil {
+		return "", nil, nil, fmt.Errorf("error preparando %s: %w", ds.Name, err)
+	}
+	return vistaOrigen, []string{vistaOrigen}, nil, nil
+}
+
+// archivoFuente devuelve la ruta, el glob o la URL que lee el DATA_SOURCE, o
+// "" si es una base de datos.
+func archivoFuente(ds global.ResourceType) string {
+	switch ds.Type {
+	case "", global.ResourceTypeFile, global.ResourceTypeS3, global.ResourceTypeHTTP:
+		return ds.ResourcePath
+	}
+	return ""
+}
+
+// conectarFuente carga la extensión y las credenciales del DATA_SOURCE y
+// devuelve la relación SQL desde la que se lee.
+func conectarFuente(db *sql.DB, ds global.ResourceType) (string, error) {
+	if extension, ok := extensionesFuente[ds.Type]; ok {
+		if err := cargarExtension(db, extension); err != nil {
+			return "", err
+		}
+	}
+
+	switch ds.Type {
+	case "", global.ResourceTypeFile, global.ResourceTypeHTTP:
+		return literal(ds.ResourcePath), nil
+	case global.ResourceTypeS3:
+		if ds.Secret != "" {
+			if err := crearSecreto(db, "s3", ds.Secret); err != nil {
+				return "", err
+			}
+		}
+		return literal(ds.ResourcePath), nil
+	case global.ResourceTypeSQLite:
+		if _, err := db.Exec(fmt.Sprintf("ATTACH %s AS %s (TYPE sqlite, READ_ONLY)", literal(ds.ResourcePath), catalogoFuente)); err != nil {
+			return "", fmt.Errorf("error abriendo %s: %w", ds.Name, err)
+		}
+	case global.ResourceTypePostgres, global.ResourceTypeMySQL:
+		if ds.Secret == "" {
+			return "", fmt.Errorf("%s: falta el secreto de conexión", ds.Name)
+		}
+		if err := crearSecreto(db, ds.Type, ds.Secret); err != nil {
+			return "", err
+		}
+		adjuntar := fmt.Sprintf("ATTACH '' AS %s (TYPE %s, SECRET %s, READ_ONLY)", catalogoFuente, ds.Type, secretoFuente)
+		if _, err := db.Exec(adjuntar); err != nil {
+			// El error del conector puede incluir la cadena de conexión.
+			return "", fmt.Errorf("error conectando %s a %s", ds.Name, ds.Type)
+		}
+	default:
+		return "", fmt.Errorf("%s: tipo de fuente de datos desconocido: %s", ds.Name, ds.Type)
+	}
+
+	if ds.Table == "" {
+		return "", fmt.Errorf("%s: falta la tabla a publicar", ds.Name)
+	}
+	partes := strings.Split(ds.Table, ".")
+	for i, parte := range partes {
+		partes[i] = identificador(parte)
+	}
+	return catalogoFuente + "." + strings.Join(partes, "."), nil
+}
+
+// cargarExtension carga la extensión, instalándola si hace falta. La carga
+// automática está desactivada en el sandbox, así que solo se cargan las que
+// pide la configuración del proveedor.
+func cargarExtension(db *sql.DB, nombre string) error {
+	if _, err := db.Exec("LOAD " + nombre); err == nil {
+		return nil
+	}
+	if _, err := db.Exec("INSTALL " + nombre); err != nil {
+		return fmt.Errorf("extensión %s no disponible: %w", nombre, err)
+	}
+	if _, err := db.Exec("LOAD " + nombre); err != nil {
+		return fmt.Errorf("extensión %s no disponible: %w", nombre, err)
+	}
+	return nil
+}
+
+// crearSecreto registra en DuckDB las credenciales del archivo de secretos.
+// El secreto es temporal y DuckDB no muestra sus valores.
+func crearSecreto(db *sql.DB, tipo, nombre string) error {
+	valores, err := LeerSecreto(nombre)
+	if err != nil {
+		return err
+	}
+	claves := make([]string, 0, len(valores))
+	for clave := range valores {
+		claves = append(claves, clave)
+	}
+	sort.Strings(claves)
+
+	opciones := []string{"TYPE " + tipo}
+	for _, clave := range claves {
+		if !opcionSecreto.MatchString(clave) {
+			return fmt.Errorf("secreto %s: opción inválida %q", nombre, clave)
+		}
+		opciones = append(opciones, clave+" "+literal(valores[clave]))
+	}
+	crear := fmt.Sprintf("CREATE TEMPORARY SECRET %s (%s)", secretoFuente, strings.Join(opciones, ", "))
+	if _, err := db.Exec(crear); err != nil {
+		// El mensaje de DuckDB puede repetir la sentencia con las credenciales.
+		return fmt.Errorf("secreto %s inválido para %s", nombre, tipo)
+	}
+	return nil
+}
+
+// LeerSecreto devuelve los valores de un secreto con las claves en
+// minúsculas; los valores env:VARIABLE se leen del entorno.
+func LeerSecreto(nombre string) (map[string]string, error) {
+	var secretos map[string]map[string]string
+	if configuration.CM != nil {
+		secretos = configuration.CM.GetConfig().Secrets
+	}
+	valores, ok := secretos[nombre]
+	if !ok {
+		return nil, fmt.Errorf("secreto no encontrado: %s", nombre)
+	}
+	res := make(map[string]string, len(valores))
+	for clave, valor := range valores {
+		if variable, ok := strings.CutPrefix(valor, "env:"); ok {
+			valor = os.Getenv(variable)
+		}
+		res[strings.ToLower(clave)] = valor
+	}
+	return res, nil
+}
@@ -0,0 +1,183 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"Veredarii/configuration"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	global "Veredarii/global"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// vistaOrigen es el nombre con el que la consulta del cliente ve la fuente de
+// datos cuando hay una política que aplicar.
+const vistaOrigen = "origen"
+
+// funcionEnmascarado es la función con la que la vista hashea columnas. La
+// consulta del cliente no la puede llamar.
+const funcionEnmascarado = "enmascarar"
+
+func politicaPara(ds global.ResourceType, entidad string) *global.DataPolicyType {
+	var general *global.DataPolicyType
+	for i, p := range ds.Policies {
+		if p.Entity == entidad {
+			return &ds.Policies[i]
+		}
+		if p.Entity == "*" {
+			general = &ds.Policies[i]
+		}
+	}
+	return general
+}
+
+// crearVistaPolitica crea sobre relacion la vista que reemplaza a {{ORIGIN}}:
+// quita las columnas ocultas, reemplaza las hasheadas o censuradas y agrega
+// los filtros obligatorios.
+func crearVistaPolitica(db *sql.DB, relacion string, p *global.DataPolicyType, clave []byte) error {
+	if len(p.Hash) > 0 {
+		if err := registrarEnmascarado(db, clave); err != nil {
+			return fmt.Errorf("error aplicando política de %s: %w", p.Entity, err)
+		}
+	}
+	seleccion := "*"
+	if len(p.Hide) > 0 {
+		seleccion += " EXCLUDE (" + listaIdentificadores(p.Hide) + ")"
+	}
+	var reemplazos []string
+	for _, col := range p.Hash {
+		reemplazos = append(reemplazos, fmt.Sprintf("%s AS %s", hmacSQL(identificador(col)), identificador(col)))
+	}
+	for _, col := range p.Redact {
+		reemplazos = append(reemplazos, fmt.Sprintf("'***' AS %s", identificador(col)))
+	}
+	if len(reemplazos) > 0 {
+		seleccion += " REPLACE (" + strings.Join(reemplazos, ", ") + ")"
+	}
+
+	vista := fmt.Sprintf("CREATE VIEW %s AS SELECT %s FROM %s", vistaOrigen, seleccion, relacion)
+	if len(p.Where) > 0 {
+		vista += " WHERE (" + strings.Join(p.Where, ") AND (") + ")"
+	}
+	if _, err := db.Exec(vista); err != nil {
+		return fmt.Errorf("error aplicando política de %s: %w", p.Entity, err)
+	}
+	return nil
+}
+
+// hmacSQL devuelve HMAC-SHA256(clave, CAST(expr AS VARCHAR)) en hexadecimal.
+// La clave vive en la función registrada, no en el texto de la vista.
+func hmacSQL(expr string) string {
+	return fmt.Sprintf("%s(CAST(%s AS VARCHAR))", funcionEnmascarado, expr)
+}
+
+var tipoVarchar, _ = duckdb.NewTypeInfo(duckdb.TYPE_VARCHAR)
+
+// hmacUDF es la función escalar de DuckDB que calcula el HMAC de cada valor.
+type hmacUDF struct {
+	clave []byte
+}
+
+func (*hmacUDF) Config() duckdb.ScalarFuncConfig {
+	return duckdb.ScalarFuncConfig{
+		InputTypeInfos: []duckdb.TypeInfo{tipoVarchar},
+		ResultTypeInfo: tipoVarchar,
+	}
+}
+
+func (h *hmacUDF) Executor() duckdb.ScalarFuncExecutor {
+	return duckdb.ScalarFuncExecutor{RowExecutor: func(valores []driver.Value) (any, error) {
+		valor, _ := valores[0].(string)
+		mac := hmac.New(sha256.New, h.clave)
+		mac.Write([]byte(valor))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	}}
+}
+
+// registrarEnmascarado registra en db la función de enmascarado con clave.
+// Las funciones se registran en el catálogo del sistema, así que la ven todas
+// las conexiones de db.
+func registrarEnmascarado(db *sql.DB, clave []byte) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return duckdb.RegisterScalarUDF(conn, funcionEnmascarado, &hmacUDF{clave: clave})
+}
+
+// claveEnmascarado es la clave del HMAC de las columnas hasheadas de un
+// DATA_SOURCE: hash_key en su secreto o, si no hay, una derivada de la
+// identidad del nodo y el nombre de la fuente.
+func claveEnmascarado(ds global.ResourceType) ([]byte, error) {
+	if ds.Secret != "" {
+		if valores, err := LeerSecreto(ds.Secret); err == nil && valores["hash_key"] != "" {
+			return []byte(valores["hash_key"]), nil
+		}
+	}
+	base, err := claveNodo()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, base)
+	mac.Write([]byte("enmascarado|" + ds.Name))
+	return mac.Sum(nil), nil
+}
+
+var claveNodo = sync.OnceValues(func() ([]byte, error) {
+	if configuration.CM == nil {
+		return nil, errors.New("sin identidad del nodo para enmascarar")
+	}
+	priv, err := global.ObtenerIdentidad(configuration.CM.GetConfig().Identity.PrivKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return priv.Raw()
+})
+
+func identificador(nombre string) string {
+	return `"` + strings.ReplaceAll(nombre, `"`, `""`) + `"`
+}
+
+func listaIdentificadores(nombres []string) string {
+	partes := make([]string, len(nombres))
+	for i, n := range nombres {
+		partes[i] = identificador(n)
+	}
+	return strings.Join(partes, ", ")
+}
+
+func literal(valor string) string {
+	return "'" + strings.ReplaceAll(valor, "'", "''") + "'"
+}
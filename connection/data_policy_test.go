@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	global "Veredarii/global"
+)
+
+func TestPoliticaPara(t *testing.T) {
+	ds := global.ResourceType{Policies: []global.DataPolicyType{
+		{Entity: "*", Hide: []string{"a"}},
+		{Entity: "ventas", Hide: []string{"b"}},
+	}}
+	if p := politicaPara(ds, "ventas"); p == nil || p.Hide[0] != "b" {
+		t.Errorf("ventas debería usar su propia política: %+v", p)
+	}
+	if p := politicaPara(ds, "otra"); p == nil || p.Hide[0] != "a" {
+		t.Errorf("otra debería usar la política general: %+v", p)
+	}
+	if p := politicaPara(global.ResourceType{}, "otra"); p != nil {
+		t.Errorf("sin políticas no debería haber política: %+v", p)
+	}
+}
+
+func TestHmacSQL(t *testing.T) {
+	for _, clave := range [][]byte{[]byte("corta"), []byte(strings.Repeat("larga", 20))} {
+		db, err := abrirSandbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err := registrarEnmascarado(db, clave); err != nil {
+			t.Fatal(err)
+		}
+		for _, valor := range []string{"", "ana@example.com", "ñandú"} {
+			var obtenido string
+			if err := db.QueryRow("SELECT " + hmacSQL(literal(valor))).Scan(&obtenido); err != nil {
+				t.Fatal(err)
+			}
+			mac := hmac.New(sha256.New, clave)
+			mac.Write([]byte(valor))
+			if esperado := hex.EncodeToString(mac.Sum(nil)); obtenido != esperado {
+				t.Errorf("hmac(%q, %q) = %s, se esperaba %s", clave, valor, obtenido, esperado)
+			}
+		}
+	}
+}
+
+func TestCrearVistaPolitica(t *testing.T) {
+	db, err := abrirSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE clientes AS SELECT * FROM (VALUES
+		(1, 'ana@example.com', 'norte', 100),
+		(2, 'luis@example.com', 'sur', 200)) t(id, email, region, saldo)`); err != nil {
+		t.Fatal(err)
+	}
+
+	clave := []byte("clave")
+	p := &global.DataPolicyType{
+		Entity: "ventas",
+		Hide:   []string{"saldo"},
+		Hash:   []string{"email"},
+		Redact: []string{"region"},
+		Where:  []string{"id > 1"},
+	}
+	if err := crearVistaPolitica(db, "clientes", p, clave); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT * FROM " + vistaOrigen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columnas, _ := rows.Columns()
+	if strings.Join(columnas, ",") != "id,email,region" {
+		t.Errorf("columnas %v, se esperaba id,email,region", columnas)
+	}
+	mac := hmac.New(sha256.New, clave)
+	mac.Write([]byte("luis@example.com"))
+	var filas int
+	for rows.Next() {
+		var id int
+		var email, region string
+		if err := rows.Scan(&id, &email, &region); err != nil {
+			t.Fatal(err)
+		}
+		filas++
+		if id != 2 || email != hex.EncodeToString(mac.Sum(nil)) || region != "***" {
+			t.Errorf("fila inesperada: %d %s %s", id, email, region)
+		}
+	}
+	if filas != 1 {
+		t.Errorf("%d filas, el filtro debería dejar una", filas)
+	}
+}
+
+// La definición de la vista no lleva la clave y la consulta del cliente no
+// puede leerla ni llamar a la función de enmascarado.
+func TestVistaPoliticaReservada(t *testing.T) {
+	db, err := abrirSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE clientes AS SELECT 'ana@example.com' AS email"); err != nil {
+		t.Fatal(err)
+	}
+	clave := []byte("clave-secreta")
+	p := &global.DataPolicyType{Entity: "ventas", Hash: []string{"email"}}
+	if err := crearVistaPolitica(db, "clientes", p, clave); err != nil {
+		t.Fatal(err)
+	}
+
+	var definicion string
+	if err := db.QueryRow("SELECT sql FROM duckdb_views() WHERE view_name = ?", vistaOrigen).Scan(&definicion); err != nil {
+		t.Fatal(err)
+	}
+	for _, rastro := range []string{string(clave), hex.EncodeToString(clave), "unhex"} {
+		if strings.Contains(definicion, rastro) {
+			t.Errorf("la definición de la vista contiene %q: %s", rastro, definicion)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT sql FROM duckdb_views()",
+		"SELECT * FROM duckdb_views",
+		"SELECT * FROM information_schema.views",
+		"SELECT * FROM sqlite_master",
+		"SELECT * FROM (WITH duckdb_views AS (SELECT 1) SELECT 1) a, duckdb_views b",
+		"SELECT " + funcionEnmascarado + "('ana@example.com')",
+		"SELECT * FROM origen WHERE email = " + strings.ToUpper(funcionEnmascarado) + "('ana@example.com')",
+	} {
+		if err := validarConsulta(db, query, []string{vistaOrigen}, nil); err == nil {
+			t.Errorf("validarConsulta(%q) debería rechazarse", query)
+		}
+	}
+}
+
+func TestClaveEnmascarado(t *testing.T) {
+	anterior := claveNodo
+	defer func() { claveNodo = anterior }()
+	claveNodo = func() ([]byte, error) { return []byte("identidad"), nil }
+
+	a, err := claveEnmascarado(global.ResourceType{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := claveEnmascarado(global.ResourceType{Name: "b"})
+	if hmac.Equal(a, b) {
+		t.Error("cada fuente debería tener su propia clave")
+	}
+}
@@ -66,6 +66,9 @@ func (n *Network) initDHT() {
 	for _, topic := range n.Resources.DATASOURCE {
 		go n.anunciarServicio(ctx, topic.Name)
 	}
+	for _, topic := range n.Resources.TCP {
+		go n.anunciarServicio(ctx, topic.Name)
+	}
 }
 
 func (n *Network) anunciarServicio(ctx context.Context, serviceName string) {
@@ -95,3 +98,23 @@ func (n *Network) BuscarServicio(ctx context.Context, serviceName string) peer.I
 
 	return ""
 }
+
+// BuscarProveedores devuelve todos los peers que anuncian el servicio, hasta
+// limite.
+func (n *Network) BuscarProveedores(ctx context.Context, serviceName string, limite int) []peer.ID {
+	routingDiscovery := routing.NewRoutingDiscovery(n.DHT)
+	peerChan, err := routingDiscovery.FindPeers(ctx, serviceName, discovery.Limit(limite))
+	if err != nil {
+		fmt.Printf("Error al buscar servicio: %v\n", err)
+		return nil
+	}
+
+	var proveedores []peer.ID
+	for peerInfo := range peerChan {
+		if peerInfo.ID == n.Host.ID() {
+			continue
+		}
+		proveedores = append(proveedores, peerInfo.ID)
+	}
+	return proveedores
+}
@@ -0,0 +1,483 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// funcionRemota es la función de tabla con la que una consulta distribuida
+// nombra una fuente de otro nodo: remote('red', 'datasource').
+const funcionRemota = "remote"
+
+// Funciones que no se pueden evaluar dos veces sin cambiar el resultado; un
+// filtro que las use no se envía al proveedor.
+var funcionesVolatiles = map[string]bool{
+	"random":          true,
+	"setseed":         true,
+	"uuid":            true,
+	"gen_random_uuid": true,
+	"nextval":         true,
+}
+
+var ErrFuenteRemota = errors.New("fuente remota no disponible")
+
+type RemoteSource struct {
+	ProviderStatus
+	Network    string `json:"network"`
+	DataSource string `json:"data_source"`
+	Query      string `json:"query"`
+
+	alias    string
+	tabla    string
+	todas    bool
+	columnas map[string]bool
+	filtros  []interface{}
+	arrow    []byte
+}
+
+type DistributedResult struct {
+	Sources []*RemoteSource          `json:"sources"`
+	Rows    []map[string]interface{} `json:"rows"`
+}
+
+// buscadorRemoto resuelve en qué red y en qué peer está una fuente remota.
+type buscadorRemoto func(ctx context.Context, red, ds string) (*Network, peer.ID, error)
+
+// ConsultaDistribuida ejecuta una consulta que combina fuentes de datos de
+// otros nodos. Cada remote('red', 'ds') se reemplaza por una tabla local con
+// la proyección y los filtros que le corresponden, pedidos al proveedor por
+// /query/1.0.0; el join y el resto de la consulta se resuelven localmente.
+func ConsultaDistribuida(ctx context.Context, query string) (*DistributedResult, error) {
+	return consultaDistribuida(ctx, query, buscarRemota)
+}
+
+// RecorrerDistribuida es ConsultaDistribuida para resultados que no conviene
+// acumular: leer recibe las filas de la consulta local.
+func RecorrerDistribuida(ctx context.Context, query string, leer func(*sql.Rows) error) (*DistributedResult, error) {
+	return recorrerDistribuida(ctx, query, buscarRemota, leer)
+}
+
+func buscarRemota(ctx context.Context, red, ds string) (*Network, peer.ID, error) {
+	n, ok := NM.GetNetwork(red)
+	if !ok {
+		return nil, "", fmt.Errorf("red no encontrada: %s", red)
+	}
+	targetID := n.BuscarServicio(ctx, ds)
+	if targetID == "" {
+		return nil, "", ErrServicioNoEncontrado
+	}
+	return n, targetID, nil
+}
+
+func consultaDistribuida(ctx context.Context, query string, buscar buscadorRemoto) (*DistributedResult, error) {
+	var filas []map[string]interface{}
+	res, err := recorrerDistribuida(ctx, query, buscar, func(rows *sql.Rows) (err error) {
+		filas, err = filasJSON(rows)
+		return err
+	})
+	if res != nil {
+		res.Rows = filas
+	}
+	return res, err
+}
+
+func recorrerDistribuida(ctx context.Context, query string, buscar buscadorRemoto, leer func(*sql.Rows) error) (*DistributedResult, error) {
+	db, err := abrirSandbox()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	arbol, err := analizarSQL(db, query)
+	if err != nil {
+		return nil, &QueryError{Code: errQueryRejected, Message: err.Error()}
+	}
+	fuentes, err := planificarRemotas(arbol)
+	if err != nil {
+		return nil, &QueryError{Code: errQueryRejected, Message: err.Error()}
+	}
+	if len(fuentes) == 0 {
+		return nil, &QueryError{Code: errQueryRejected, Message: "la consulta no usa fuentes remotas"}
+	}
+	final, err := generarSQL(db, arbol)
+	if err != nil {
+		return nil, err
+	}
+	tablas := make([]string, len(fuentes))
+	for i, f := range fuentes {
+		tablas[i] = f.tabla
+		if f.Query, err = consultaRemota(db, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := validarConsulta(db, final, tablas, nil); err != nil {
+		return nil, &QueryError{Code: errQueryRejected, Message: err.Error()}
+	}
+
+	res := &DistributedResult{Sources: fuentes}
+	var wg sync.WaitGroup
+	for _, f := range fuentes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obtenerRemota(ctx, f, buscar)
+		}()
+	}
+	wg.Wait()
+	for _, f := range fuentes {
+		if f.Status != estadoOK {
+			// Sin todas las entradas el join no tiene sentido.
+			return res, fmt.Errorf("%w: %s/%s: %v", ErrFuenteRemota, f.Network, f.DataSource, f.Error)
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	for _, f := range fuentes {
+		if err := cargarArrow(ctx, conn, f.arrow, f.tabla); err != nil {
+			return res, fmt.Errorf("error cargando %s/%s: %w", f.Network, f.DataSource, err)
+		}
+		f.arrow = nil
+	}
+
+	log.Debug("Consulta distribuida local: ", final)
+	rows, err := conn.QueryContext(ctx, final)
+	if err != nil {
+		return res, &QueryError{Code: errQueryFailed, Message: err.Error()}
+	}
+	defer rows.Close()
+	if err := leer(rows); err != nil {
+		return res, &QueryError{Code: errQueryFailed, Message: err.Error()}
+	}
+	return res, nil
+}
+
+func obtenerRemota(ctx context.Context, f *RemoteSource, buscar buscadorRemoto) {
+	n, targetID, err := buscar(ctx, f.Network, f.DataSource)
+	if err != nil {
+		f.Status = estadoError
+		f.Error = &QueryError{Code: errDataSourceNotFound, Message: err.Error()}
+		return
+	}
+	log.Debug("Consulta remota a ", f.Network, "/", f.DataSource, ": ", f.Query)
+	parcial := n.consultarProveedor(ctx, targetID, f.DataSource, QueryType{Query: f.Query, Format: "arrow"})
+	f.ProviderStatus = parcial.estado
+	f.arrow = parcial.arrow
+}
+
+// planificarRemotas busca las llamadas a remote() en el FROM de cada SELECT,
+// las reemplaza por la tabla local que recibirá los datos y calcula qué
+// columnas y filtros se pueden delegar al proveedor.
+func planificarRemotas(arbol interface{}) ([]*RemoteSource, error) {
+	var fuentes []*RemoteSource
+	err := recorrerArbol(arbol, func(nodo map[string]interface{}) error {
+		if nodo["type"] != "SELECT_NODE" {
+			return nil
+		}
+		return recorrerFrom(nodo["from_table"], true, func(ref map[string]interface{}, seguro bool) error {
+			f, err := nuevaFuenteRemota(ref, len(fuentes))
+			if err != nil || f == nil {
+				return err
+			}
+			if seguro && !f.todas {
+				f.filtros = filtrosDelegables(nodo["where_clause"], f.alias)
+			}
+			fuentes = append(fuentes, f)
+			reemplazarPorTabla(ref, f.tabla, f.alias)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	proyecciones(arbol, fuentes)
+	return fuentes, nil
+}
+
+// recorrerFrom visita las funciones de tabla de un FROM. seguro indica si un
+// filtro del WHERE sobre esa tabla se puede aplicar antes del join sin cambiar
+// el resultado, lo que solo vale fuera del lado opcional de un outer join.
+func recorrerFrom(v interface{}, seguro bool, visitar func(map[string]interface{}, bool) error) error {
+	ref, _ := v.(map[string]interface{})
+	switch ref["type"] {
+	case "TABLE_FUNCTION":
+		return visitar(ref, seguro)
+	case "JOIN":
+		izq, der := false, false
+		switch {
+		case ref["ref_type"] == "POSITIONAL" || ref["ref_type"] == "ASOF":
+		case ref["ref_type"] == "CROSS" || ref["join_type"] == "INNER":
+			izq, der = seguro, seguro
+		case ref["join_type"] == "LEFT" || ref["join_type"] == "SEMI" || ref["join_type"] == "ANTI":
+			izq = seguro
+		case ref["join_type"] == "RIGHT":
+			der = seguro
+		}
+		if err := recorrerFrom(ref["left"], izq, visitar); err != nil {
+			return err
+		}
+		return recorrerFrom(ref["right"], der, visitar)
+	}
+	return nil
+}
+
+func nuevaFuenteRemota(ref map[string]interface{}, i int) (*RemoteSource, error) {
+	funcion, _ := ref["function"].(map[string]interface{})
+	nombre, _ := funcion["function_name"].(string)
+	if !strings.EqualFold(nombre, funcionRemota) {
+		return nil, nil
+	}
+	hijos, _ := funcion["children"].([]interface{})
+	if len(hijos) != 2 || valorConstante(hijos[0]) == "" || valorConstante(hijos[1]) == "" {
+		return nil, fmt.Errorf("%s espera dos constantes: red y fuente de datos", funcionRemota)
+	}
+	alias, _ := ref["alias"].(string)
+	if alias == "" {
+		alias = funcionRemota
+	}
+	// Con columnas renombradas en el alias, los nombres de la consulta no
+	// son los del proveedor.
+	renombres, _ := ref["column_name_alias"].([]interface{})
+	return &RemoteSource{
+		Network:    valorConstante(hijos[0]),
+		DataSource: valorConstante(hijos[1]),
+		alias:      alias,
+		tabla:      fmt.Sprintf("remoto_%d", i),
+		todas:      len(renombres) > 0,
+		columnas:   map[string]bool{},
+	}, nil
+}
+
+// reemplazarPorTabla convierte el nodo TABLE_FUNCTION en una referencia a la
+// tabla local, conservando el nombre con el que la consulta lo califica.
+func reemplazarPorTabla(ref map[string]interface{}, tabla, alias string) {
+	renombres := ref["column_name_alias"]
+	if renombres == nil {
+		renombres = []interface{}{}
+	}
+	for k := range ref {
+		delete(ref, k)
+	}
+	ref["type"] = "BASE_TABLE"
+	ref["alias"] = alias
+	ref["sample"] = nil
+	ref["schema_name"] = ""
+	ref["catalog_name"] = ""
+	ref["table_name"] = tabla
+	ref["column_name_alias"] = renombres
+}
+
+// proyecciones reúne las columnas que la consulta usa de cada fuente. Una
+// columna sin calificar o un * sin tabla obligan a pedir todas, porque no se
+// puede saber a qué fuente pertenecen.
+func proyecciones(arbol interface{}, fuentes []*RemoteSource) {
+	todas := false
+	recorrerArbol(arbol, func(nodo map[string]interface{}) error {
+		switch nodo["class"] {
+		case "COLUMN_REF":
+			nombres := nombresColumna(nodo)
+			if len(nombres) != 2 {
+				todas = true
+				return nil
+			}
+			for _, f := range fuentes {
+				if strings.EqualFold(nombres[0], f.alias) {
+					f.columnas[nombres[1]] = true
+				}
+			}
+		case "STAR":
+			relacion, _ := nodo["relation_name"].(string)
+			if relacion == "" {
+				todas = true
+			}
+			for _, f := range fuentes {
+				if strings.EqualFold(relacion, f.alias) {
+					f.todas = true
+				}
+			}
+		}
+		if usando, _ := nodo["using_columns"].([]interface{}); len(usando) > 0 || nodo["ref_type"] == "NATURAL" {
+			todas = true
+		}
+		return nil
+	})
+	for _, f := range fuentes {
+		if todas || len(f.columnas) == 0 {
+			f.todas = true
+		}
+	}
+}
+
+// filtrosDelegables separa el WHERE en sus conjunciones y devuelve, sin la
+// calificación, las que solo dependen de columnas de la fuente.
+func filtrosDelegables(where interface{}, alias string) []interface{} {
+	var filtros []interface{}
+	for _, c := range conjunciones(where) {
+		if !soloDeFuente(c, alias) {
+			continue
+		}
+		copia := copiarArbol(c)
+		recorrerArbol(copia, func(nodo map[string]interface{}) error {
+			if nodo["class"] == "COLUMN_REF" {
+				nombres, _ := nodo["column_names"].([]interface{})
+				nodo["column_names"] = nombres[1:]
+			}
+			return nil
+		})
+		filtros = append(filtros, copia)
+	}
+	return filtros
+}
+
+func conjunciones(v interface{}) []interface{} {
+	nodo, _ := v.(map[string]interface{})
+	if nodo == nil {
+		return nil
+	}
+	if nodo["type"] != "CONJUNCTION_AND" {
+		return []interface{}{nodo}
+	}
+	var res []interface{}
+	hijos, _ := nodo["children"].([]interface{})
+	for _, h := range hijos {
+		res = append(res, conjunciones(h)...)
+	}
+	return res
+}
+
+func soloDeFuente(v interface{}, alias string) bool {
+	columnas := 0
+	err := recorrerArbol(v, func(nodo map[string]interface{}) error {
+		switch nodo["class"] {
+		case "COLUMN_REF":
+			nombres := nombresColumna(nodo)
+			if len(nombres) != 2 || !strings.EqualFold(nombres[0], alias) {
+				return errNoDelegable
+			}
+			columnas++
+		case "SUBQUERY", "LAMBDA", "PARAMETER", "WINDOW", "STAR":
+			return errNoDelegable
+		case "FUNCTION":
+			nombre, _ := nodo["function_name"].(string)
+			if funcionesVolatiles[strings.ToLower(nombre)] {
+				return errNoDelegable
+			}
+		}
+		return nil
+	})
+	return err == nil && columnas > 0
+}
+
+var errNoDelegable = fmt.Errorf("no delegable")
+
+func nombresColumna(nodo map[string]interface{}) []string {
+	lista, _ := nodo["column_names"].([]interface{})
+	nombres := make([]string, len(lista))
+	for i, n := range lista {
+		nombres[i], _ = n.(string)
+	}
+	return nombres
+}
+
+// consultaRemota arma la consulta que recibe el proveedor a partir de una
+// plantilla analizada por DuckDB, para que columnas y filtros se impriman con
+// su sintaxis.
+func consultaRemota(db *sql.DB, f *RemoteSource) (string, error) {
+	arbol, err := analizarSQL(db, `SELECT * FROM "{{ORIGIN}}"`)
+	if err != nil {
+		return "", err
+	}
+	nodo := arbol.(map[string]interface{})["node"].(map[string]interface{})
+	if !f.todas {
+		columnas := make([]string, 0, len(f.columnas))
+		for c := range f.columnas {
+			columnas = append(columnas, c)
+		}
+		sort.Strings(columnas)
+		lista := make([]interface{}, len(columnas))
+		for i, c := range columnas {
+			lista[i] = map[string]interface{}{
+				"class":          "COLUMN_REF",
+				"type":           "COLUMN_REF",
+				"alias":          "",
+				"query_location": json.Number("0"),
+				"column_names":   []interface{}{c},
+			}
+		}
+		nodo["select_list"] = lista
+	}
+	switch len(f.filtros) {
+	case 0:
+	case 1:
+		nodo["where_clause"] = f.filtros[0]
+	default:
+		nodo["where_clause"] = map[string]interface{}{
+			"class":          "CONJUNCTION",
+			"type":           "CONJUNCTION_AND",
+			"alias":          "",
+			"query_location": json.Number("0"),
+			"children":       f.filtros,
+		}
+	}
+	return generarSQL(db, arbol)
+}
+
+func generarSQL(db *sql.DB, arbol interface{}) (string, error) {
+	datos, err := json.Marshal(map[string]interface{}{
+		"error":      false,
+		"statements": []interface{}{arbol},
+	})
+	if err != nil {
+		return "", err
+	}
+	var query string
+	if err := db.QueryRow("SELECT json_deserialize_sql(?::JSON)", string(datos)).Scan(&query); err != nil {
+		return "", fmt.Errorf("error generando consulta: %w", err)
+	}
+	return query, nil
+}
+
+func copiarArbol(v interface{}) interface{} {
+	datos, _ := json.Marshal(v)
+	var copia interface{}
+	dec := json.NewDecoder(bytes.NewReader(datos))
+	dec.UseNumber()
+	dec.Decode(&copia)
+	return copia
+}
@@ -0,0 +1,260 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/marcboeker/go-duckdb"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxProveedores = 50
+
+	// vistaResultados es el nombre con el que la consulta de mezcla ve la
+	// unión de los resultados parciales.
+	vistaResultados = "resultados"
+)
+
+var ErrSinProveedores = errors.New("ningún proveedor respondió")
+
+type ProviderStatus struct {
+	Provider   string      `json:"provider"`
+	Status     string      `json:"status"`
+	Rows       int64       `json:"rows"`
+	DurationMs int64       `json:"duration_ms"`
+	Error      *QueryError `json:"error,omitempty"`
+}
+
+type FederatedResult struct {
+	Providers []ProviderStatus         `json:"providers"`
+	Partial   bool                     `json:"partial"`
+	Rows      []map[string]interface{} `json:"rows"`
+}
+
+type resultadoParcial struct {
+	estado ProviderStatus
+	arrow  []byte
+}
+
+// QueryFederada envía la consulta a todos los proveedores del DATA_SOURCE y
+// une los resultados localmente. Cada resultado parcial lleva la columna
+// _provider. merge se ejecuta sobre {{RESULTS}}, la unión de los parciales;
+// sirve, por ejemplo, para sumar agregados parciales.
+func (n *Network) QueryFederada(ctx context.Context, service string, query QueryType, merge string) (*FederatedResult, error) {
+	proveedores := n.BuscarProveedores(ctx, service, maxProveedores)
+	if len(proveedores) == 0 {
+		return nil, ErrServicioNoEncontrado
+	}
+	return n.consultaFederada(ctx, proveedores, service, query, merge)
+}
+
+func (n *Network) consultaFederada(ctx context.Context, proveedores []peer.ID, service string, query QueryType, merge string) (*FederatedResult, error) {
+	query.Format = "arrow"
+	parciales := make([]resultadoParcial, len(proveedores))
+	var wg sync.WaitGroup
+	for i, p := range proveedores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parciales[i] = n.consultarProveedor(ctx, p, service, query)
+		}()
+	}
+	wg.Wait()
+
+	res := &FederatedResult{}
+	var exitosos []resultadoParcial
+	for _, p := range parciales {
+		res.Providers = append(res.Providers, p.estado)
+		if p.estado.Status == estadoOK {
+			exitosos = append(exitosos, p)
+		} else {
+			res.Partial = true
+		}
+	}
+	if len(exitosos) == 0 {
+		return res, ErrSinProveedores
+	}
+
+	filas, err := mezclarResultados(ctx, exitosos, merge)
+	if err != nil {
+		return res, err
+	}
+	res.Rows = filas
+	return res, nil
+}
+
+func (n *Network) consultarProveedor(ctx context.Context, p peer.ID, service string, query QueryType) resultadoParcial {
+	parcial := resultadoParcial{estado: ProviderStatus{Provider: p.String(), Status: estadoError}}
+	var buf bytes.Buffer
+	cierre, err := n.Query(ctx, p, query, service, &limiteParcial{w: &buf, resto: maxBytesParcial})
+	if cierre != nil {
+		parcial.estado.Rows = cierre.Rows
+		parcial.estado.DurationMs = cierre.DurationMs
+	}
+	if err != nil {
+		log.Warn("Consulta federada fallida en ", p, ": ", err)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			qe = &QueryError{Code: errQueryFailed, Message: err.Error()}
+		}
+		parcial.estado.Error = qe
+		return parcial
+	}
+	parcial.estado.Status = estadoOK
+	parcial.arrow = buf.Bytes()
+	return parcial
+}
+
+// maxBytesParcial acota lo que se guarda en memoria del resultado de cada
+// proveedor antes de mezclar.
+const maxBytesParcial = 256 << 20
+
+var errParcialGrande = errors.New("el resultado parcial excede el máximo en memoria")
+
+type limiteParcial struct {
+	w     io.Writer
+	resto int64
+}
+
+func (l *limiteParcial) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.resto {
+		return 0, errParcialGrande
+	}
+	l.resto -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// mezclarResultados carga cada resultado Arrow en una tabla temporal, las une
+// por nombre de columna y ejecuta la consulta de mezcla.
+func mezclarResultados(ctx context.Context, parciales []resultadoParcial, merge string) ([]map[string]interface{}, error) {
+	db, err := abrirSandbox()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var union []string
+	for i, p := range parciales {
+		tabla := fmt.Sprintf("parcial_%d", i)
+		if err := cargarArrow(ctx, conn, p.arrow, tabla); err != nil {
+			return nil, fmt.Errorf("error cargando resultado de %s: %w", p.estado.Provider, err)
+		}
+		union = append(union, fmt.Sprintf("SELECT *, %s AS _provider FROM %s", literal(p.estado.Provider), tabla))
+	}
+	vista := "CREATE TEMP VIEW " + vistaResultados + " AS " + strings.Join(union, " UNION ALL BY NAME ")
+	if _, err := conn.ExecContext(ctx, vista); err != nil {
+		return nil, fmt.Errorf("error uniendo resultados: %w", err)
+	}
+
+	if merge == "" {
+		merge = "SELECT * FROM {{RESULTS}}"
+	}
+	merge = strings.ReplaceAll(merge, "{{RESULTS}}", vistaResultados)
+	if err := validarConsulta(db, merge, []string{vistaResultados}, nil); err != nil {
+		return nil, &QueryError{Code: errQueryRejected, Message: err.Error()}
+	}
+
+	rows, err := conn.QueryContext(ctx, merge)
+	if err != nil {
+		return nil, &QueryError{Code: errQueryFailed, Message: err.Error()}
+	}
+	defer rows.Close()
+	return filasJSON(rows)
+}
+
+func cargarArrow(ctx context.Context, conn *sql.Conn, datos []byte, tabla string) error {
+	lector, err := ipc.NewReader(bytes.NewReader(datos))
+	if err != nil {
+		return err
+	}
+	defer lector.Release()
+
+	vista := tabla + "_arrow"
+	err = conn.Raw(func(dc interface{}) error {
+		a, err := duckdb.NewArrowFromConn(dc.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		liberar, err := a.RegisterView(lector, vista)
+		if err != nil {
+			return err
+		}
+		defer liberar()
+		_, err = dc.(driver.ExecerContext).ExecContext(ctx, "CREATE TEMP TABLE "+tabla+" AS SELECT * FROM "+vista, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "DROP VIEW IF EXISTS "+vista)
+	return err
+}
+
+func filasJSON(rows *sql.Rows) ([]map[string]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	valores := make([]interface{}, len(cols))
+	punteros := make([]interface{}, len(cols))
+	for i := range valores {
+		punteros[i] = &valores[i]
+	}
+	filas := []map[string]interface{}{}
+	for rows.Next() {
+		if err := rows.Scan(punteros...); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			switch v := valores[i].(type) {
+			case []byte:
+				m[col] = string(v)
+			case duckdb.Decimal:
+				m[col] = v.Float64()
+			default:
+				m[col] = v
+			}
+		}
+		filas = append(filas, m)
+	}
+	return filas, rows.Err()
+}
@@ -0,0 +1,284 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"Veredarii/global"
+	"bufio"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"bazil.org/fuse"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Lecturas por bloques de los archivos remotos. Cada handle abierto mantiene
+// un stream de rangos, una caché LRU de bloques y, si la lectura es
+// secuencial, pide por adelantado los bloques siguientes.
+const (
+	maxRangoArchivo = 4 << 20
+	bloqueArchivo   = 1 << 20
+	bloquesEnCache  = 16
+	bloquesAdelanto = 2
+)
+
+var errArchivoCerrado = errors.New("archivo remoto cerrado")
+
+// errServidorArchivo es un rechazo del proveedor; reintentar no sirve.
+type errServidorArchivo string
+
+func (e errServidorArchivo) Error() string { return "error del servidor: " + string(e) }
+
+// archivoRemoto es el stream de rangos de un archivo. Las peticiones se
+// serializan y, si el stream se cae, se reabre una vez.
+type archivoRemoto struct {
+	n       *Network
+	dest    peer.ID
+	nombre  string
+	size    int64
+	mu      sync.Mutex
+	s       network.Stream
+	r       *bufio.Reader
+	cerrado bool
+}
+
+func (n *Network) abrirArchivoRemoto(dest peer.ID, nombre string) (*archivoRemoto, error) {
+	a := &archivoRemoto{n: n, dest: dest, nombre: nombre}
+	if err := a.conectar(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archivoRemoto) conectar() error {
+	s, err := a.n.Host.NewStream(context.Background(), a.dest, global.ProtocolFileSystemRange)
+	if err != nil {
+		return err
+	}
+	s.Write([]byte(a.nombre + "\n"))
+	r := bufio.NewReader(s)
+	status, err := r.ReadString('\n')
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	status = strings.TrimSpace(status)
+	if !strings.HasPrefix(status, "OK ") {
+		s.Reset()
+		return errServidorArchivo(status)
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(status, "OK "), 10, 64)
+	if err != nil {
+		s.Reset()
+		return fmt.Errorf("tamaño inválido: %s", status)
+	}
+	a.s, a.r, a.size = s, r, size
+	return nil
+}
+
+// leer pide hasta largo bytes desde offset. Devuelve menos al llegar al final.
+func (a *archivoRemoto) leer(offset int64, largo int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	for intento := 0; intento < 2; intento++ {
+		if a.cerrado {
+			return nil, errArchivoCerrado
+		}
+		if a.s == nil {
+			if err = a.conectar(); err != nil {
+				return nil, err
+			}
+		}
+		var datos []byte
+		datos, err = a.pedir(offset, largo)
+		if err == nil {
+			return datos, nil
+		}
+		a.s.Reset()
+		a.s, a.r = nil, nil
+		var rechazo errServidorArchivo
+		if errors.As(err, &rechazo) {
+			return nil, err
+		}
+		log.Warn(fmt.Sprintf("Reabriendo stream de '%s': %v", a.nombre, err))
+	}
+	return nil, err
+}
+
+func (a *archivoRemoto) pedir(offset int64, largo int) ([]byte, error) {
+	if _, err := fmt.Fprintf(a.s, "%d %d\n", offset, largo); err != nil {
+		return nil, err
+	}
+	status, err := a.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	status = strings.TrimSpace(status)
+	if !strings.HasPrefix(status, "OK ") {
+		return nil, errServidorArchivo(status)
+	}
+	total, err := strconv.Atoi(strings.TrimPrefix(status, "OK "))
+	if err != nil || total < 0 || total > largo {
+		return nil, fmt.Errorf("respuesta inválida: %s", status)
+	}
+	datos := make([]byte, total)
+	if _, err := io.ReadFull(a.r, datos); err != nil {
+		return nil, err
+	}
+	return datos, nil
+}
+
+func (a *archivoRemoto) cerrar() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cerrado = true
+	if a.s != nil {
+		a.s.Close()
+		a.s, a.r = nil, nil
+	}
+}
+
+type bloqueRemoto struct {
+	indice int64
+	listo  chan struct{}
+	datos  []byte
+	err    error
+	elem   *list.Element
+}
+
+// FileHandle es un archivo remoto abierto en el FUSE. relleno, si hay
+// caché de archivos, guarda los bloques leídos.
+type FileHandle struct {
+	remoto    *archivoRemoto
+	relleno   *rellenoCache
+	mu        sync.Mutex
+	bloques   map[int64]*bloqueRemoto
+	orden     *list.List
+	siguiente int64
+}
+
+func nuevoFileHandle(remoto *archivoRemoto, relleno *rellenoCache) *FileHandle {
+	return &FileHandle{remoto: remoto, relleno: relleno, bloques: map[int64]*bloqueRemoto{}, orden: list.New()}
+}
+
+// bloque devuelve el bloque i, pidiéndolo al proveedor si no está en caché.
+func (h *FileHandle) bloque(i int64) *bloqueRemoto {
+	h.mu.Lock()
+	if b, ok := h.bloques[i]; ok {
+		h.orden.MoveToFront(b.elem)
+		h.mu.Unlock()
+		return b
+	}
+	b := &bloqueRemoto{indice: i, listo: make(chan struct{})}
+	b.elem = h.orden.PushFront(b)
+	h.bloques[i] = b
+	for h.orden.Len() > bloquesEnCache {
+		viejo := h.orden.Remove(h.orden.Back()).(*bloqueRemoto)
+		delete(h.bloques, viejo.indice)
+	}
+	h.mu.Unlock()
+
+	go func() {
+		b.datos, b.err = h.remoto.leer(i*bloqueArchivo, bloqueArchivo)
+		close(b.listo)
+		if b.err == nil {
+			h.relleno.bloque(i, b.datos)
+		}
+	}()
+	return b
+}
+
+// descartar saca de la caché un bloque fallido para reintentarlo después.
+func (h *FileHandle) descartar(b *bloqueRemoto) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bloques[b.indice] == b {
+		h.orden.Remove(b.elem)
+		delete(h.bloques, b.indice)
+	}
+}
+
+func (h *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	inicio := req.Offset
+	fin := min(req.Offset+int64(req.Size), h.remoto.size)
+	if inicio >= fin {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+
+	h.mu.Lock()
+	secuencial := inicio == h.siguiente
+	h.siguiente = fin
+	h.mu.Unlock()
+
+	datos := resp.Data[:0]
+	for i := inicio / bloqueArchivo; i*bloqueArchivo < fin; i++ {
+		b := h.bloque(i)
+		select {
+		case <-b.listo:
+		case <-ctx.Done():
+			return fuse.EINTR
+		}
+		if b.err != nil {
+			h.descartar(b)
+			log.Error(fmt.Sprintf("Error al leer '%s' en %d: %v", h.remoto.nombre, i*bloqueArchivo, b.err))
+			return fuse.EIO
+		}
+		desde := max(inicio-i*bloqueArchivo, 0)
+		hasta := min(fin-i*bloqueArchivo, int64(len(b.datos)))
+		if desde >= hasta {
+			break
+		}
+		datos = append(datos, b.datos[desde:hasta]...)
+		if hasta < bloqueArchivo {
+			break
+		}
+	}
+	resp.Data = datos
+
+	if secuencial {
+		ultimo := (fin - 1) / bloqueArchivo
+		for i := ultimo + 1; i <= ultimo+bloquesAdelanto && i*bloqueArchivo < h.remoto.size; i++ {
+			h.bloque(i)
+		}
+	}
+	return nil
+}
+
+func (h *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.remoto.cerrar()
+	h.relleno.descartar()
+	return nil
+}
@@ -0,0 +1,467 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"Veredarii/global"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"bazil.org/fuse"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	bytesCacheArchivosDefecto = 1 << 30
+	maxNombresCache           = 100000
+	intervaloIndiceCache      = 5 * time.Second
+)
+
+// FileCache guarda los archivos remotos por su sha256 en Dir/objetos. Un
+// objeto solo entra si su contenido coincide con el hash que informó el
+// proveedor. El índice recuerda el último stat de cada nombre en caché para
+// leerlo sin conexión. La fecha de modificación de cada objeto marca su
+// último uso y ordena el LRU.
+type FileCache struct {
+	dir       string
+	maxBytes  int64
+	offline   bool
+	prefetch  bool
+	mu        sync.Mutex
+	bytes     int64
+	lru       *list.List
+	objetos   map[string]*list.Element
+	conocidos map[string]FileStat
+	descargas map[string]bool
+	// guardado es la escritura pendiente del índice; los cambios se juntan
+	// durante intervaloIndiceCache.
+	guardado  *time.Timer
+	escritura sync.Mutex
+}
+
+type objetoCache struct {
+	hash string
+	size int64
+}
+
+func NewFileCache(conf *global.FileCacheType) (*FileCache, error) {
+	c := &FileCache{
+		dir:       conf.Dir,
+		maxBytes:  bytesCacheArchivosDefecto,
+		offline:   conf.Offline,
+		prefetch:  conf.Prefetch || conf.Offline,
+		lru:       list.New(),
+		objetos:   map[string]*list.Element{},
+		conocidos: map[string]FileStat{},
+		descargas: map[string]bool{},
+	}
+	if conf.MaxBytes > 0 {
+		c.maxBytes = conf.MaxBytes
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, "objetos"), 0700); err != nil {
+		return nil, err
+	}
+
+	entradas, err := os.ReadDir(filepath.Join(c.dir, "objetos"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, e := range entradas {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			os.Remove(filepath.Join(c.dir, "objetos", e.Name()))
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+	for _, info := range infos {
+		c.objetos[info.Name()] = c.lru.PushBack(&objetoCache{hash: info.Name(), size: info.Size()})
+		c.bytes += info.Size()
+	}
+	if datos, err := os.ReadFile(filepath.Join(c.dir, "index.json")); err == nil {
+		if err := json.Unmarshal(datos, &c.conocidos); err != nil {
+			log.Warn("Índice de la caché de archivos inválido: ", err)
+		}
+	}
+	for nombre, stat := range c.conocidos {
+		if _, ok := c.objetos[stat.SHA256]; !ok {
+			delete(c.conocidos, nombre)
+		}
+	}
+	c.liberar()
+	log.Info(fmt.Sprintf("Caché de archivos en %s: %d objetos, %d bytes", c.dir, c.lru.Len(), c.bytes))
+	return c, nil
+}
+
+func (c *FileCache) ruta(hash string) string {
+	return filepath.Join(c.dir, "objetos", hash)
+}
+
+// abrir entrega el objeto con ese hash si está en la caché.
+func (c *FileCache) abrir(hash string) (*os.File, bool) {
+	if c == nil || hash == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	elem, ok := c.objetos[hash]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	ahora := time.Now()
+	os.Chtimes(c.ruta(hash), ahora, ahora)
+	f, err := os.Open(c.ruta(hash))
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// abrirVigente entrega la copia en caché del contenido que informa ahora el
+// proveedor en stat, y recuerda ese stat para nombre.
+func (c *FileCache) abrirVigente(nombre string, stat FileStat) (*os.File, bool) {
+	f, ok := c.abrir(stat.SHA256)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	if c.conocidos[nombre].SHA256 != stat.SHA256 {
+		c.recordar(nombre, stat)
+	}
+	c.mu.Unlock()
+	return f, true
+}
+
+// recordar guarda el stat de un nombre en el índice. Con el índice lleno se
+// olvida un nombre cualquiera. Se llama con mu tomado.
+func (c *FileCache) recordar(nombre string, stat FileStat) {
+	if _, ok := c.conocidos[nombre]; !ok && len(c.conocidos) >= maxNombresCache {
+		for viejo := range c.conocidos {
+			delete(c.conocidos, viejo)
+			break
+		}
+	}
+	c.conocidos[nombre] = stat
+	c.programarIndice()
+}
+
+// programarIndice agenda la escritura del índice. Se llama con mu tomado.
+func (c *FileCache) programarIndice() {
+	if c.guardado == nil {
+		c.guardado = time.AfterFunc(intervaloIndiceCache, c.guardarIndice)
+	}
+}
+
+func (c *FileCache) guardarIndice() {
+	c.escritura.Lock()
+	defer c.escritura.Unlock()
+	c.mu.Lock()
+	c.guardado = nil
+	datos, _ := json.Marshal(c.conocidos)
+	c.mu.Unlock()
+
+	tmp := filepath.Join(c.dir, "index.json.tmp")
+	if err := os.WriteFile(tmp, datos, 0600); err != nil {
+		log.Error("Error al guardar el índice de la caché: ", err)
+		return
+	}
+	os.Rename(tmp, filepath.Join(c.dir, "index.json"))
+}
+
+// sinConexion devuelve el último stat conocido de un nombre cuando el modo
+// offline está activo y su contenido sigue en la caché.
+func (c *FileCache) sinConexion(nombre string) (FileStat, bool) {
+	if c == nil || !c.offline {
+		return FileStat{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stat, ok := c.conocidos[nombre]
+	if !ok {
+		return FileStat{}, false
+	}
+	_, ok = c.objetos[stat.SHA256]
+	return stat, ok
+}
+
+// listarSinConexion arma, en modo offline, el listado de un directorio con
+// los nombres recordados bajo él cuyo contenido sigue en la caché.
+func (c *FileCache) listarSinConexion(dir string) ([]fuse.Dirent, bool) {
+	if c == nil || !c.offline {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vistos := map[string]fuse.DirentType{}
+	for nombre, stat := range c.conocidos {
+		resto, ok := strings.CutPrefix(nombre, dir+"/")
+		if !ok {
+			continue
+		}
+		if _, ok := c.objetos[stat.SHA256]; !ok {
+			continue
+		}
+		if primero, _, anidado := strings.Cut(resto, "/"); anidado {
+			vistos[primero] = fuse.DT_Dir
+		} else {
+			vistos[resto] = fuse.DT_File
+		}
+	}
+	entradas := make([]fuse.Dirent, 0, len(vistos))
+	for nombre, tipo := range vistos {
+		entradas = append(entradas, fuse.Dirent{Name: nombre, Type: tipo})
+	}
+	sort.Slice(entradas, func(i, j int) bool { return entradas[i].Name < entradas[j].Name })
+	return entradas, len(entradas) > 0
+}
+
+// preparar se llama al abrir un archivo remoto. Con prefetch, o en modo
+// offline, trae en segundo plano el archivo completo; si no, devuelve el
+// relleno que arma la copia con los bloques que se lean. Sin el hash del
+// proveedor no hay con qué validar la copia y no se guarda.
+func (c *FileCache) preparar(n *Network, dest peer.ID, nombre string, stat FileStat) *rellenoCache {
+	if c == nil || stat.SHA256 == "" || stat.Size == 0 || stat.Size > c.maxBytes {
+		return nil
+	}
+	if c.prefetch {
+		c.descargar(n, dest, nombre, stat)
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "objetos"), "*.tmp")
+	if err != nil {
+		log.Error("Error al preparar la caché: ", err)
+		return nil
+	}
+	faltan := map[int64]bool{}
+	for i := int64(0); i*bloqueArchivo < stat.Size; i++ {
+		faltan[i] = true
+	}
+	return &rellenoCache{c: c, nombre: nombre, stat: stat, f: tmp, faltan: faltan}
+}
+
+// descargar trae en segundo plano el archivo completo y lo agrega a la caché
+// si coincide con el stat.
+func (c *FileCache) descargar(n *Network, dest peer.ID, nombre string, stat FileStat) {
+	c.mu.Lock()
+	if c.descargas[nombre] {
+		c.mu.Unlock()
+		return
+	}
+	c.descargas[nombre] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.descargas, nombre)
+			c.mu.Unlock()
+		}()
+		if err := c.traer(n, dest, nombre, stat); err != nil {
+			log.Error(fmt.Sprintf("Error al guardar '%s' en la caché: %v", nombre, err))
+		}
+	}()
+}
+
+func (c *FileCache) traer(n *Network, dest peer.ID, nombre string, stat FileStat) error {
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "objetos"), "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := n.RequestFile(dest, nombre, tmp)
+	if err != nil {
+		return err
+	}
+	if size != stat.Size {
+		return fmt.Errorf("contenido distinto del stat (%d bytes)", size)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return c.agregar(tmp.Name(), nombre, stat)
+}
+
+// agregar mueve a la caché el temporal con el contenido de nombre y lo
+// recuerda en el índice, si su hash es el que informó el proveedor. Un
+// archivo que cambió mientras se leía arma una copia mezclada que no pasa.
+func (c *FileCache) agregar(tmp string, nombre string, stat FileStat) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if hash := hex.EncodeToString(hasher.Sum(nil)); hash != stat.SHA256 {
+		os.Remove(tmp)
+		return fmt.Errorf("el contenido no coincide con el hash del proveedor")
+	}
+	if err := os.Rename(tmp, c.ruta(stat.SHA256)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.objetos[stat.SHA256]; ok {
+		c.lru.MoveToFront(elem)
+	} else {
+		c.objetos[stat.SHA256] = c.lru.PushFront(&objetoCache{hash: stat.SHA256, size: size})
+		c.bytes += size
+	}
+	c.recordar(nombre, stat)
+	c.liberar()
+	log.Debug(fmt.Sprintf("'%s' guardado en la caché (%d bytes)", nombre, size))
+	return nil
+}
+
+// liberar borra los objetos menos usados hasta quedar bajo el límite, y los
+// nombres que los usaban. Los handles abiertos siguen leyendo el archivo
+// borrado.
+func (c *FileCache) liberar() {
+	borrados := map[string]bool{}
+	for c.bytes > c.maxBytes && c.lru.Len() > 0 {
+		o := c.lru.Remove(c.lru.Back()).(*objetoCache)
+		delete(c.objetos, o.hash)
+		c.bytes -= o.size
+		os.Remove(c.ruta(o.hash))
+		borrados[o.hash] = true
+	}
+	if len(borrados) == 0 {
+		return
+	}
+	for nombre, stat := range c.conocidos {
+		if borrados[stat.SHA256] {
+			delete(c.conocidos, nombre)
+		}
+	}
+	c.programarIndice()
+}
+
+// rellenoCache arma en un temporal la copia de un archivo con los bloques
+// que lee un handle. Cuando están todos la agrega a la caché; si el handle
+// se cierra antes, se descarta.
+type rellenoCache struct {
+	c      *FileCache
+	nombre string
+	stat   FileStat
+	mu     sync.Mutex
+	f      *os.File
+	faltan map[int64]bool
+}
+
+func (r *rellenoCache) bloque(i int64, datos []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || !r.faltan[i] {
+		return
+	}
+	if int64(len(datos)) != min(bloqueArchivo, r.stat.Size-i*bloqueArchivo) {
+		r.descartarBloqueado()
+		return
+	}
+	if _, err := r.f.WriteAt(datos, i*bloqueArchivo); err != nil {
+		log.Error("Error al escribir en la caché: ", err)
+		r.descartarBloqueado()
+		return
+	}
+	delete(r.faltan, i)
+	if len(r.faltan) > 0 {
+		return
+	}
+	f := r.f
+	r.f = nil
+	go func() {
+		err := f.Close()
+		if err == nil {
+			err = r.c.agregar(f.Name(), r.nombre, r.stat)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("Error al guardar '%s' en la caché: %v", r.nombre, err))
+			os.Remove(f.Name())
+		}
+	}()
+}
+
+func (r *rellenoCache) descartar() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.descartarBloqueado()
+}
+
+func (r *rellenoCache) descartarBloqueado() {
+	if r.f != nil {
+		r.f.Close()
+		os.Remove(r.f.Name())
+		r.f = nil
+	}
+}
+
+// archivoLocal es un archivo abierto desde la caché.
+type archivoLocal struct {
+	f *os.File
+}
+
+func (h *archivoLocal) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	leidos, err := h.f.ReadAt(resp.Data[:req.Size], req.Offset)
+	if err != nil && err != io.EOF {
+		log.Error("Error al leer desde la caché: ", err)
+		return fuse.EIO
+	}
+	resp.Data = resp.Data[:leidos]
+	return nil
+}
+
+func (h *archivoLocal) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.f.Close()
+}
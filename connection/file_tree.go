@@ -0,0 +1,170 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"Veredarii/global"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Un FILE cuyo ResourcePath es un directorio publica todo el árbol. Los
+// nombres en el protocolo son "<recurso>/<ruta relativa>" con "/" como
+// separador; Include y Exclude se comparan con la ruta relativa.
+
+var errNoPublicado = errors.New("archivo no publicado")
+
+// FileEntry es una entrada del listado de un directorio.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// resolverArchivo traduce un nombre del protocolo a la ruta local. Rechaza
+// rutas que salgan del directorio publicado, también mediante enlaces, y las
+// que no pasan los filtros del recurso.
+func (n *Network) resolverArchivo(nombre string) (global.ResourceType, string, os.FileInfo, error) {
+	raiz, resto, _ := strings.Cut(nombre, "/")
+	for _, resource := range n.Resources.FILE {
+		if resource.Name != raiz {
+			continue
+		}
+		if resto == "" {
+			info, err := os.Stat(resource.ResourcePath)
+			return resource, resource.ResourcePath, info, err
+		}
+		if !iofs.ValidPath(resto) {
+			return resource, "", nil, errNoPublicado
+		}
+		base, err := filepath.EvalSymlinks(resource.ResourcePath)
+		if err != nil {
+			return resource, "", nil, err
+		}
+		ruta, err := filepath.EvalSymlinks(filepath.Join(base, filepath.FromSlash(resto)))
+		if err != nil {
+			return resource, "", nil, err
+		}
+		if !dentroDe(base, ruta) {
+			return resource, "", nil, errNoPublicado
+		}
+		info, err := os.Stat(ruta)
+		if err != nil {
+			return resource, "", nil, err
+		}
+		if !publicadoResuelto(resource, base, resto, ruta, info.IsDir()) {
+			return resource, "", nil, errNoPublicado
+		}
+		return resource, ruta, info, nil
+	}
+	return global.ResourceType{}, "", nil, errNoPublicado
+}
+
+func dentroDe(base, ruta string) bool {
+	rel, err := filepath.Rel(base, ruta)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// publicado aplica los filtros a una ruta relativa. Un directorio excluido
+// oculta todo su contenido; Include solo se aplica a los archivos.
+func publicado(resource global.ResourceType, rel string, dir bool) bool {
+	for p := rel; p != "."; p = path.Dir(p) {
+		if coincide(resource.Exclude, p) {
+			return false
+		}
+	}
+	if dir || len(resource.Include) == 0 {
+		return true
+	}
+	return coincide(resource.Include, rel)
+}
+
+// publicadoResuelto aplica los filtros al nombre pedido y también a la ruta
+// real dentro de base, para que un enlace como ok.txt -> secret.key no
+// esquive un Exclude.
+func publicadoResuelto(resource global.ResourceType, base, rel, ruta string, dir bool) bool {
+	if !publicado(resource, rel, dir) {
+		return false
+	}
+	real, err := filepath.Rel(base, ruta)
+	if err != nil {
+		return false
+	}
+	return real == "." || publicado(resource, filepath.ToSlash(real), dir)
+}
+
+func coincide(patrones []string, rel string) bool {
+	for _, patron := range patrones {
+		if ok, _ := doublestar.Match(patron, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listarPublicados lee un directorio ya resuelto y deja las entradas que
+// pasan los filtros. Los enlaces se siguen solo si apuntan dentro del árbol.
+func listarPublicados(resource global.ResourceType, nombre string, ruta string) ([]FileEntry, error) {
+	base, err := filepath.EvalSymlinks(resource.ResourcePath)
+	if err != nil {
+		return nil, err
+	}
+	_, prefijo, _ := strings.Cut(nombre, "/")
+	entradas, err := os.ReadDir(ruta)
+	if err != nil {
+		return nil, err
+	}
+	lista := []FileEntry{}
+	for _, e := range entradas {
+		completa := filepath.Join(ruta, e.Name())
+		destino := completa
+		if e.Type()&os.ModeSymlink != 0 {
+			destino, err = filepath.EvalSymlinks(completa)
+			if err != nil || !dentroDe(base, destino) {
+				continue
+			}
+		}
+		info, err := os.Stat(completa)
+		if err != nil || !(info.IsDir() || info.Mode().IsRegular()) {
+			continue
+		}
+		if !publicadoResuelto(resource, base, path.Join(prefijo, e.Name()), destino, info.IsDir()) {
+			continue
+		}
+		entrada := FileEntry{Name: e.Name(), Dir: info.IsDir(), ModTime: info.ModTime()}
+		if !entrada.Dir {
+			entrada.Size = info.Size()
+		}
+		lista = append(lista, entrada)
+	}
+	return lista, nil
+}
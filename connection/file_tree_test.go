@@ -0,0 +1,103 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	global "Veredarii/global"
+)
+
+func arbolPrueba(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	for _, archivo := range []string{"datos/a.csv", "datos/b.txt", "secret.key", "privado/x.csv"} {
+		ruta := filepath.Join(base, filepath.FromSlash(archivo))
+		if err := os.MkdirAll(filepath.Dir(ruta), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(ruta, []byte(archivo), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enlaces := map[string]string{
+		"ok.txt":         "secret.key",
+		"datos/c.csv":    "../privado/x.csv",
+		"datos/fuera":    os.TempDir(),
+		"datos/otro.csv": "a.csv",
+	}
+	for enlace, destino := range enlaces {
+		if err := os.Symlink(destino, filepath.Join(base, filepath.FromSlash(enlace))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestResolverArchivo(t *testing.T) {
+	base := arbolPrueba(t)
+	n := &Network{Resources: global.ResourcesType{FILE: []global.ResourceType{{
+		Name:         "pub",
+		ResourcePath: base,
+		Include:      []string{"**/*.csv", "*.txt"},
+		Exclude:      []string{"*.key", "privado"},
+	}}}}
+
+	casos := []struct {
+		nombre string
+		ok     bool
+	}{
+		{"pub", true},
+		{"pub/datos", true},
+		{"pub/datos/a.csv", true},
+		{"pub/datos/otro.csv", true},
+		{"pub/datos/b.txt", false},
+		{"pub/secret.key", false},
+		{"pub/ok.txt", false},
+		{"pub/privado/x.csv", false},
+		{"pub/datos/c.csv", false},
+		{"pub/datos/fuera", false},
+		{"pub/../secret.key", false},
+		{"pub/datos/../secret.key", false},
+		{"pub/no-existe.csv", false},
+		{"otro/datos/a.csv", false},
+	}
+	for _, c := range casos {
+		_, _, _, err := n.resolverArchivo(c.nombre)
+		if (err == nil) != c.ok {
+			t.Errorf("resolverArchivo(%q): error %v, se esperaba publicado=%v", c.nombre, err, c.ok)
+		}
+	}
+}
+
+func TestListarPublicados(t *testing.T) {
+	base := arbolPrueba(t)
+	resource := global.ResourceType{
+		Name:         "pub",
+		ResourcePath: base,
+		Include:      []string{"**/*.csv", "*.txt"},
+		Exclude:      []string{"*.key", "privado"},
+	}
+	esperados := map[string]string{
+		"pub":       "datos",
+		"pub/datos": "a.csv,otro.csv",
+	}
+	for nombre, esperado := range esperados {
+		ruta := base
+		if nombre != "pub" {
+			ruta = filepath.Join(base, "datos")
+		}
+		entradas, err := listarPublicados(resource, nombre, ruta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nombres []string
+		for _, e := range entradas {
+			nombres = append(nombres, e.Name)
+		}
+		if got := strings.Join(nombres, ","); got != esperado {
+			t.Errorf("listarPublicados(%q) = %q, se esperaba %q", nombre, got, esperado)
+		}
+	}
+}
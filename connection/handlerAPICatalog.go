@@ -0,0 +1,247 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+var errSinOpenAPI = errors.New("el servicio no publica documento OpenAPI")
+
+type CatalogEntry struct {
+	Service  string      `json:"service"`
+	Provider string      `json:"provider,omitempty"`
+	Spec     interface{} `json:"spec,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// handleAPICatalogStream entrega el documento OpenAPI de un API publicado. Lo
+// puede leer quien tenga permiso para llamar al servicio.
+func (n *Network) handleAPICatalogStream(s network.Stream) {
+	defer s.Close()
+	remotePeer := s.Conn().RemotePeer()
+
+	msg, err := leerEnvelop(bufio.NewReader(s))
+	if err != nil {
+		log.Error("Error leyendo solicitud de catálogo: ", err)
+		return
+	}
+
+	if !RBAC.Allowed(remotePeer, n.Name, global.ProtocolAPIProxy, msg.Service) {
+		log.Debug("Denegado, sin permiso al servicio: ", remotePeer.String(), n.Name, global.ProtocolAPICatalog, msg.Service)
+		s.Reset()
+		return
+	}
+
+	resp := &global.Envelop{Id: msg.Id, Service: msg.Service}
+	spec, err := n.documentoOpenAPI(msg.Service)
+	if err != nil {
+		log.Warn("Catálogo de ", msg.Service, ": ", err)
+		resp.Extra = frameError
+		resp.Payload = []byte(err.Error())
+	} else {
+		resp.Payload = spec
+	}
+	out, _ := proto.Marshal(resp)
+	writeDelimited(s, out)
+}
+
+// documentoOpenAPI lee el documento desde un archivo o desde el propio
+// servicio y lo normaliza a JSON, aunque esté escrito en YAML.
+func (n *Network) documentoOpenAPI(service string) ([]byte, error) {
+	var origen string
+	for _, resource := range n.Resources.API {
+		if resource.Name == service {
+			origen = resource.OpenAPI
+			break
+		}
+	}
+	if origen == "" {
+		return nil, errSinOpenAPI
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(origen, "http://") || strings.HasPrefix(origen, "https://") {
+		var resp *http.Response
+		resp, err = clienteProxy.Get(origen)
+		if err != nil {
+			return nil, fmt.Errorf("error obteniendo documento OpenAPI: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error obteniendo documento OpenAPI: %s", resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+	} else {
+		data, err = os.ReadFile(origen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo documento OpenAPI: %w", err)
+	}
+
+	if json.Valid(data) {
+		return data, nil
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("documento OpenAPI inválido: %w", err)
+	}
+	return json.Marshal(normalizarYAML(doc))
+}
+
+// normalizarYAML convierte los mapas con claves interface{} que produce yaml
+// en mapas que encoding/json sabe serializar.
+func normalizarYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = normalizarYAML(val)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = normalizarYAML(x[i])
+		}
+	}
+	return v
+}
+
+func (n *Network) PedirOpenAPI(targetID peer.ID, service string) ([]byte, error) {
+	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolAPICatalog)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo stream: %w", err)
+	}
+	defer s.Close()
+
+	data, _ := proto.Marshal(&global.Envelop{Id: uuid.New().String(), Service: service})
+	if _, err := writeDelimited(s, data); err != nil {
+		return nil, fmt.Errorf("error enviando solicitud: %w", err)
+	}
+	res, err := leerEnvelop(bufio.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	if res.Extra == frameError {
+		return nil, fmt.Errorf("error del servidor: %s", res.Payload)
+	}
+	return res.Payload, nil
+}
+
+// Catalogo reúne los documentos OpenAPI de los APIs remotos de la red.
+// gateway es la URL base de la interfaz local; con ella se reescriben los
+// servidores de cada documento para que los clientes generados pasen por la
+// malla.
+func (n *Network) Catalogo(gateway string) []CatalogEntry {
+	servicios := make([]global.ResourceType, 0, len(n.RemoteResources.API))
+	for _, service := range n.RemoteResources.API {
+		if service.Type != global.ResourceTypeGRPC {
+			servicios = append(servicios, service)
+		}
+	}
+
+	catalogo := make([]CatalogEntry, len(servicios))
+	var wg sync.WaitGroup
+	for i, service := range servicios {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			catalogo[i] = n.CatalogoServicio(service.Name, gateway+"/"+n.Name+"/"+service.Name)
+		}()
+	}
+	wg.Wait()
+	return catalogo
+}
+
+func (n *Network) CatalogoServicio(service string, ruta string) CatalogEntry {
+	entrada := CatalogEntry{Service: service}
+	targetID := n.BuscarServicio(context.Background(), service)
+	if targetID == "" {
+		entrada.Error = ErrServicioNoEncontrado.Error()
+		return entrada
+	}
+	entrada.Provider = targetID.String()
+
+	data, err := n.PedirOpenAPI(targetID, service)
+	if err != nil {
+		entrada.Error = err.Error()
+		return entrada
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		entrada.Error = "documento OpenAPI inválido: " + err.Error()
+		return entrada
+	}
+	reescribirServidores(spec, ruta)
+	entrada.Spec = spec
+	return entrada
+}
+
+// reescribirServidores apunta el documento a la ruta local del servicio, tanto
+// en OpenAPI 3 (servers) como en Swagger 2 (host, basePath y schemes).
+func reescribirServidores(spec map[string]interface{}, ruta string) {
+	if _, ok := spec["swagger"]; ok {
+		esquema, resto, _ := strings.Cut(ruta, "://")
+		host, base, _ := strings.Cut(resto, "/")
+		spec["schemes"] = []string{esquema}
+		spec["host"] = host
+		spec["basePath"] = "/" + base
+		return
+	}
+	spec["servers"] = []map[string]string{{"url": ruta}}
+	// Los servidores definidos por ruta u operación tienen prioridad sobre los
+	// globales, así que se eliminan.
+	paths, _ := spec["paths"].(map[string]interface{})
+	for _, item := range paths {
+		operaciones, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delete(operaciones, "servers")
+		for _, op := range operaciones {
+			if op, ok := op.(map[string]interface{}); ok {
+				delete(op, "servers")
+			}
+		}
+	}
+}
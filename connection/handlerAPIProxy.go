@@ -28,9 +28,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -42,6 +49,21 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// APIProxyMeta viaja en el campo Extra de la respuesta con el estado y los
+// encabezados del servicio, además del modo en que sigue el stream.
+type APIProxyMeta struct {
+	Mode   string      `json:"mode,omitempty"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+const (
+	modoSSE     = "sse"
+	modoUpgrade = "upgrade"
+
+	tiempoInactividad = 5 * time.Minute
+)
+
 func (n *Network) handleAPIProxyStream(s network.Stream) {
 	defer s.Close()
 	remotePeer := s.Conn().RemotePeer()
@@ -53,9 +75,10 @@ func (n *Network) handleAPIProxyStream(s network.Stream) {
 		return
 	}
 
+	br := bufio.NewReader(s)
 	for {
 		msg := &global.Envelop{}
-		data, err := readDelimited(s)
+		data, err := readDelimited(br)
 		if err != nil {
 			if err != io.EOF {
 				log.Printf("Error leyendo stream: %v", err)
@@ -74,6 +97,14 @@ func (n *Network) handleAPIProxyStream(s network.Stream) {
 			return
 		}
 
+		resource := n.recursoAPI(msg.Service)
+		if ok, espera := n.permitirCuota(remotePeer, resource); !ok {
+			log.Warn("Cuota excedida por ", RBAC.Entity(remotePeer), " en ", msg.Service)
+			responderErrorAPI(s, msg.Id, http.StatusTooManyRequests, "cuota excedida", espera)
+			continue
+		}
+		salida := n.medirCuota(s, remotePeer, resource)
+
 		b := bufio.NewReader(bytes.NewReader(msg.Payload))
 		req, err := http.ReadRequest(b)
 		if err != nil {
@@ -82,10 +113,9 @@ func (n *Network) handleAPIProxyStream(s network.Stream) {
 		}
 		fmt.Printf("📩 Recibido de %s: %s\n", s.Conn().RemotePeer().String()[:6], req.URL.String())
 
-		nuevoHost := "localhost:3000"
-		nuevaRuta := "/echo"
-		urlDestino := fmt.Sprintf("http://%s%s?%s", nuevoHost, nuevaRuta, req.URL.RawQuery)
-		proxyReq, err := http.NewRequest(req.Method, urlDestino, req.Body)
+		destino := destinoAPI(resource, req.URL)
+		nuevoHost := destino.Host
+		proxyReq, err := http.NewRequest(req.Method, destino.String(), req.Body)
 		if err != nil {
 			log.Printf("Error creando nuevo request: %v", err)
 			return
@@ -93,32 +123,205 @@ func (n *Network) handleAPIProxyStream(s network.Stream) {
 		proxyReq.Header = req.Header
 		proxyReq.Host = nuevoHost
 
-		client := &http.Client{}
-		resp, err := client.Do(proxyReq)
+		cb := n.breaker(resource)
+		if err := cb.Entrar(); err != nil {
+			log.Warn("Llamada rechazada a ", msg.Service, ": ", err)
+			responderErrorAPI(s, msg.Id, http.StatusServiceUnavailable, err.Error(), cb.ReintentarEn())
+			continue
+		}
+
+		if esUpgrade(req) {
+			n.proxyUpgrade(salida, br, msg.Id, proxyReq, nuevoHost, cb)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		limite := time.AfterFunc(cb.Timeout(), cancel)
+		resp, err := clienteProxy.Do(proxyReq.WithContext(ctx))
 		if err != nil {
 			log.Printf("Error replicando el llamado: %v", err)
+			vencido := !limite.Stop()
+			cancel()
+			cb.Salir(false)
+			responderErrorAPI(s, msg.Id, estadoFallo(vencido), err.Error(), 0)
+			continue
+		}
+		if esEventStream(resp) {
+			limite.Stop()
+			n.proxyEventStream(salida, msg.Id, resp)
+			cancel()
+			cb.Salir(true)
 			return
 		}
 		bodyBytes, err := io.ReadAll(resp.Body)
+		vencido := !limite.Stop()
+		resp.Body.Close()
+		cancel()
 		if err != nil {
 			log.Printf("Error leyendo el cuerpo de la respuesta: %v", err)
-			return
+			cb.Salir(false)
+			responderErrorAPI(s, msg.Id, estadoFallo(vencido), err.Error(), 0)
+			continue
 		}
-		defer resp.Body.Close()
+		cb.Salir(resp.StatusCode < http.StatusInternalServerError)
 
+		meta, _ := json.Marshal(APIProxyMeta{Status: resp.StatusCode, Header: resp.Header})
 		response := &global.Envelop{
 			Id:      uuid.New().String(),
 			Payload: bodyBytes,
+			Extra:   string(meta),
 		}
 
 		resData, _ := proto.Marshal(response)
-		if _, err := writeDelimited(s, resData); err != nil {
+		if _, err := writeDelimited(salida, resData); err != nil {
 			log.Printf("Error respondiendo: %v", err)
 			return
 		}
 	}
 }
 
+var clienteProxy = &http.Client{}
+
+// destinoAPI arma la URL del servicio publicado: la base es ResourcePath y se
+// le agrega la ruta pedida por el consumidor bajo /{red}/{servicio}.
+func destinoAPI(resource global.ResourceType, pedida *url.URL) *url.URL {
+	base := resource.ResourcePath
+	if base == "" {
+		base = "localhost:3000/echo"
+	}
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	destino, err := url.Parse(base)
+	if err != nil {
+		destino = &url.URL{Scheme: "http", Host: "localhost:3000", Path: "/echo"}
+	}
+	// Se limpia antes de unir para que ".." no salga de la base.
+	if ruta := path.Clean("/" + pedida.Path); ruta != "/" {
+		destino = destino.JoinPath(ruta)
+	}
+	destino.RawQuery = pedida.RawQuery
+	return destino
+}
+
+func (n *Network) recursoAPI(service string) global.ResourceType {
+	for _, resource := range n.Resources.API {
+		if resource.Name == service {
+			return resource
+		}
+	}
+	return global.ResourceType{Name: service}
+}
+
+// estadoFallo distingue un timeout del servicio de cualquier otro error.
+func estadoFallo(vencido bool) int {
+	if vencido {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// responderErrorAPI contesta sin llegar al servicio, con un cuerpo JSON y, si
+// corresponde, el encabezado Retry-After.
+func responderErrorAPI(s network.Stream, id string, status int, mensaje string, reintentar time.Duration) error {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if reintentar > 0 {
+		header.Set("Retry-After", strconv.Itoa(segundosReintento(reintentar)))
+	}
+	meta, _ := json.Marshal(APIProxyMeta{Status: status, Header: header})
+	body, _ := json.Marshal(map[string]string{"error": mensaje})
+	response := &global.Envelop{
+		Id:      id,
+		Payload: body,
+		Extra:   string(meta),
+	}
+	resData, _ := proto.Marshal(response)
+	_, err := writeDelimited(s, resData)
+	return err
+}
+
+func esUpgrade(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != "" &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+func esEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// proxyUpgrade entrega la petición al servicio por TCP y, desde ese momento,
+// deja el stream en modo crudo: la respuesta 101 y los frames (WebSocket u
+// otro protocolo) pasan sin interpretar en ambos sentidos.
+func (n *Network) proxyUpgrade(s network.Stream, br *bufio.Reader, id string, req *http.Request, host string, cb *CircuitBreaker) {
+	conn, err := net.DialTimeout("tcp", host, cb.Timeout())
+	if err != nil {
+		log.Printf("Error conectando al servicio: %v", err)
+		cb.Salir(false)
+		responderErrorAPI(s, id, http.StatusBadGateway, err.Error(), 0)
+		return
+	}
+	defer conn.Close()
+
+	if err := req.Write(conn); err != nil {
+		log.Printf("Error replicando el llamado: %v", err)
+		cb.Salir(false)
+		responderErrorAPI(s, id, http.StatusBadGateway, err.Error(), 0)
+		return
+	}
+	// El cupo queda tomado mientras dure la conexión.
+	defer cb.Salir(true)
+
+	meta, _ := json.Marshal(APIProxyMeta{Mode: modoUpgrade, Status: http.StatusSwitchingProtocols})
+	response := &global.Envelop{Id: id, Extra: string(meta)}
+	resData, _ := proto.Marshal(response)
+	if _, err := writeDelimited(s, resData); err != nil {
+		log.Printf("Error respondiendo: %v", err)
+		return
+	}
+
+	fmt.Printf("🔁 Conexión %s abierta hacia %s\n", req.Header.Get("Upgrade"), host)
+	unirConexiones(conn, s, br, tiempoInactividad)
+}
+
+// proxyEventStream reenvía cada bloque de eventos apenas llega del servicio.
+// El fin del flujo se marca con un payload vacío.
+func (n *Network) proxyEventStream(s network.Stream, id string, resp *http.Response) {
+	defer resp.Body.Close()
+
+	meta, _ := json.Marshal(APIProxyMeta{Mode: modoSSE, Status: resp.StatusCode, Header: resp.Header})
+	response := &global.Envelop{Id: id, Extra: string(meta)}
+	resData, _ := proto.Marshal(response)
+	if _, err := writeDelimited(s, resData); err != nil {
+		log.Printf("Error respondiendo: %v", err)
+		return
+	}
+
+	inactivo := time.AfterFunc(tiempoInactividad, func() {
+		log.Debug("Event stream inactivo, cerrando: ", id)
+		resp.Body.Close()
+	})
+	defer inactivo.Stop()
+
+	buf := make([]byte, 32*1024)
+	for {
+		nr, err := resp.Body.Read(buf)
+		if nr > 0 {
+			inactivo.Reset(tiempoInactividad)
+			out, _ := proto.Marshal(&global.Envelop{Id: id, Payload: buf[:nr]})
+			if _, err := writeDelimited(s, out); err != nil {
+				log.Debug("El consumidor cerró el event stream: ", err)
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	out, _ := proto.Marshal(&global.Envelop{Id: id})
+	writeDelimited(s, out)
+}
+
 func (n *Network) Conversar(targetID peer.ID, service string, payload []byte) []byte {
 	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolAPIProxy)
 	if err != nil {
@@ -145,6 +348,116 @@ func (n *Network) Conversar(targetID peer.ID, service string, payload []byte) []
 	return nil
 }
 
+// ConversarHTTP reenvía la petición y escribe la respuesta en w con el estado
+// y los encabezados del servicio. Los event streams se van entregando a
+// medida que llegan y las conexiones con Upgrade quedan unidas al stream.
+func (n *Network) ConversarHTTP(targetID peer.ID, service string, payload []byte, w http.ResponseWriter) error {
+	s, br, res, meta, err := n.enviarAPI(targetID, service, payload)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return escribirRespuestaAPI(s, br, res, meta, w)
+}
+
+// enviarAPI manda la petición y lee el primer mensaje de la respuesta. El
+// stream queda abierto por si la respuesta continúa (SSE o Upgrade).
+func (n *Network) enviarAPI(targetID peer.ID, service string, payload []byte) (network.Stream, *bufio.Reader, *global.Envelop, APIProxyMeta, error) {
+	var meta APIProxyMeta
+	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolAPIProxy)
+	if err != nil {
+		return nil, nil, nil, meta, fmt.Errorf("error abriendo stream: %w", err)
+	}
+
+	msg := &global.Envelop{
+		Id:      uuid.New().String(),
+		Service: service,
+		Payload: payload,
+	}
+	data, _ := proto.Marshal(msg)
+	if _, err := writeDelimited(s, data); err != nil {
+		s.Close()
+		return nil, nil, nil, meta, fmt.Errorf("error enviando petición: %w", err)
+	}
+
+	br := bufio.NewReader(s)
+	res, err := leerEnvelop(br)
+	if err != nil {
+		s.Close()
+		return nil, nil, nil, meta, fmt.Errorf("error leyendo respuesta: %w", err)
+	}
+
+	if res.Extra != "" {
+		if err := json.Unmarshal([]byte(res.Extra), &meta); err != nil {
+			s.Close()
+			return nil, nil, nil, meta, fmt.Errorf("error decodificando respuesta: %w", err)
+		}
+	}
+	return s, br, res, meta, nil
+}
+
+func escribirRespuestaAPI(s network.Stream, br *bufio.Reader, res *global.Envelop, meta APIProxyMeta, w http.ResponseWriter) error {
+	if meta.Mode == modoUpgrade {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			return fmt.Errorf("la conexión local no admite Upgrade")
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return fmt.Errorf("error tomando la conexión local: %w", err)
+		}
+		defer conn.Close()
+		if pendiente := rw.Reader.Buffered(); pendiente > 0 {
+			buf, _ := rw.Reader.Peek(pendiente)
+			s.Write(buf)
+		}
+		unirConexiones(conn, s, br, tiempoInactividad)
+		return nil
+	}
+
+	for k, v := range meta.Header {
+		if k == "Connection" || (k == "Content-Length" && meta.Mode == modoSSE) {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	if meta.Status != 0 {
+		w.WriteHeader(meta.Status)
+	}
+
+	if meta.Mode != modoSSE {
+		w.Write(res.Payload)
+		return nil
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		chunk, err := readDelimited(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("error leyendo event stream: %w", err)
+		}
+		ev := &global.Envelop{}
+		if err := proto.Unmarshal(chunk, ev); err != nil {
+			return fmt.Errorf("error unmarshal protobuf: %w", err)
+		}
+		if len(ev.Payload) == 0 {
+			return nil
+		}
+		if _, err := w.Write(ev.Payload); err != nil {
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
 func writeDelimited(w io.Writer, data []byte) (int, error) {
 	buf := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutUvarint(buf, uint64(len(data)))
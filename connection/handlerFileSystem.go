@@ -27,14 +27,16 @@ import (
 	"Veredarii/configuration"
 	"Veredarii/global"
 	"bufio"
-	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -55,32 +57,126 @@ func (n *Network) handleFileFetch(s network.Stream) {
 	}
 	filePath = strings.TrimSpace(filePath)
 
-	for _, resource := range n.Resources.FILE {
-		if resource.Name == filePath {
-			file, err := os.Open(resource.ResourcePath)
-			if err != nil {
-				s.Write([]byte("ERR: " + err.Error() + "\n"))
-				return
-			}
-			defer file.Close()
+	resource, ruta, fileInfo, err := n.resolverArchivo(filePath)
+	if err != nil {
+		s.Write([]byte("-1\n"))
+		return
+	}
+	if fileInfo.IsDir() {
+		s.Write([]byte("ERR: es un directorio\n"))
+		return
+	}
+	if ok, espera := n.permitirCuota(s.Conn().RemotePeer(), resource); !ok {
+		s.Write([]byte(fmt.Sprintf("ERR: cuota excedida; retry-after=%d\n", segundosReintento(espera))))
+		return
+	}
+	s = n.medirCuota(s, s.Conn().RemotePeer(), resource)
+	file, err := os.Open(ruta)
+	if err != nil {
+		s.Write([]byte("ERR: " + err.Error() + "\n"))
+		return
+	}
+	defer file.Close()
 
-			s.Write([]byte("OK\n"))
+	s.Write([]byte("OK\n"))
 
-			written, err := io.Copy(s, file)
-			if err != nil {
-				fmt.Printf("Error durante el envío: %v\n", err)
-				return
-			}
+	written, err := io.Copy(s, file)
+	if err != nil {
+		fmt.Printf("Error durante el envío: %v\n", err)
+		return
+	}
+
+	fmt.Printf("📤 Archivo '%s' enviado correctamente (%d bytes)\n", filePath, written)
+}
+
+func (n *Network) handleFileStat(s network.Stream) {
+	defer s.Close()
+
+	reader := bufio.NewReader(s)
+	filePath, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	filePath = strings.TrimSpace(filePath)
+
+	_, _, fileInfo, err := n.resolverArchivo(filePath)
+	if err != nil {
+		s.Write([]byte("-1\n"))
+		return
+	}
+	s.Write([]byte(fmt.Sprintf("%d\n", fileInfo.Size())))
+}
+
+// handleFileRange atiende lecturas por rango. La primera línea trae el nombre
+// del archivo y se responde "OK <tamaño>"; luego cada línea "<offset> <largo>"
+// se responde con "OK <n>" seguido de n bytes, hasta que el cliente cierra.
+func (n *Network) handleFileRange(s network.Stream) {
+	defer s.Close()
 
-			fmt.Printf("📤 Archivo '%s' enviado correctamente (%d bytes)\n", filePath, written)
+	reader := bufio.NewReader(s)
+	filePath, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	filePath = strings.TrimSpace(filePath)
+
+	resource, ruta, fileInfo, err := n.resolverArchivo(filePath)
+	if err != nil {
+		s.Write([]byte("-1\n"))
+		return
+	}
+	if fileInfo.IsDir() {
+		s.Write([]byte("ERR: es un directorio\n"))
+		return
+	}
+	if ok, espera := n.permitirCuota(s.Conn().RemotePeer(), resource); !ok {
+		s.Write([]byte(fmt.Sprintf("ERR: cuota excedida; retry-after=%d\n", segundosReintento(espera))))
+		return
+	}
+	s = n.medirCuota(s, s.Conn().RemotePeer(), resource)
+	file, err := os.Open(ruta)
+	if err != nil {
+		s.Write([]byte("ERR: " + err.Error() + "\n"))
+		return
+	}
+	defer file.Close()
+	fileInfo, err = file.Stat()
+	if err != nil {
+		s.Write([]byte("ERR: " + err.Error() + "\n"))
+		return
+	}
+	s.Write([]byte(fmt.Sprintf("OK %d\n", fileInfo.Size())))
+
+	buf := make([]byte, maxRangoArchivo)
+	var enviados int64
+	for {
+		linea, err := reader.ReadString('\n')
+		if err != nil {
+			log.Debug(fmt.Sprintf("Rangos de '%s' terminados (%d bytes)", filePath, enviados))
+			return
+		}
+		var offset, largo int64
+		if _, err := fmt.Sscanf(linea, "%d %d", &offset, &largo); err != nil || offset < 0 || largo < 0 {
+			s.Write([]byte("ERR: rango inválido\n"))
+			return
+		}
+		largo = min(largo, maxRangoArchivo)
+		leidos, err := file.ReadAt(buf[:largo], offset)
+		if err != nil && err != io.EOF {
+			s.Write([]byte("ERR: " + err.Error() + "\n"))
 			return
 		}
+		s.Write([]byte(fmt.Sprintf("OK %d\n", leidos)))
+		if _, err := s.Write(buf[:leidos]); err != nil {
+			return
+		}
+		enviados += int64(leidos)
 	}
-	s.Write([]byte("-1\n"))
-
 }
 
-func (n *Network) handleFileStat(s network.Stream) {
+// handleFileList entrega en una línea JSON las entradas publicadas de un
+// directorio de un FILE.
+func (n *Network) handleFileList(s network.Stream) {
 	defer s.Close()
 
 	reader := bufio.NewReader(s)
@@ -90,18 +186,122 @@ func (n *Network) handleFileStat(s network.Stream) {
 	}
 	filePath = strings.TrimSpace(filePath)
 
-	for _, resource := range n.Resources.FILE {
-		if resource.Name == filePath {
-			fileInfo, err := os.Stat(resource.ResourcePath)
-			if err != nil {
-				s.Write([]byte("-1\n"))
-				return
-			}
+	resource, ruta, fileInfo, err := n.resolverArchivo(filePath)
+	if err != nil || !fileInfo.IsDir() {
+		s.Write([]byte("-1\n"))
+		return
+	}
+	if ok, espera := n.permitirCuota(s.Conn().RemotePeer(), resource); !ok {
+		s.Write([]byte(fmt.Sprintf("ERR: cuota excedida; retry-after=%d\n", segundosReintento(espera))))
+		return
+	}
+	s = n.medirCuota(s, s.Conn().RemotePeer(), resource)
+	entradas, err := listarPublicados(resource, filePath, ruta)
+	if err != nil {
+		s.Write([]byte("ERR: " + err.Error() + "\n"))
+		return
+	}
+	out, _ := json.Marshal(entradas)
+	s.Write(append(out, '\n'))
+}
 
-			s.Write([]byte(fmt.Sprintf("%d\n", fileInfo.Size())))
-			break
+// FileStat es la respuesta del stat extendido. El hash identifica el
+// contenido en la caché de archivos de los consumidores; los directorios no
+// lo llevan.
+type FileStat struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Dir     bool      `json:"dir,omitempty"`
+}
+
+func (n *Network) handleFileStatX(s network.Stream) {
+	defer s.Close()
+
+	reader := bufio.NewReader(s)
+	filePath, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	filePath = strings.TrimSpace(filePath)
+
+	resource, ruta, fileInfo, err := n.resolverArchivo(filePath)
+	if err != nil {
+		s.Write([]byte("-1\n"))
+		return
+	}
+	if ok, espera := n.permitirCuota(s.Conn().RemotePeer(), resource); !ok {
+		s.Write([]byte(fmt.Sprintf("ERR: cuota excedida; retry-after=%d\n", segundosReintento(espera))))
+		return
+	}
+	s = n.medirCuota(s, s.Conn().RemotePeer(), resource)
+	stat := FileStat{Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), Dir: fileInfo.IsDir()}
+	if !stat.Dir {
+		if stat.SHA256, err = resumenesArchivos.resumen(ruta, fileInfo); err != nil {
+			log.Error("Error al calcular el hash: ", err)
+			s.Write([]byte("-1\n"))
+			return
 		}
 	}
+	out, _ := json.Marshal(stat)
+	s.Write(append(out, '\n'))
+}
+
+const maxResumenesArchivos = 4096
+
+// resumenesArchivos recuerda el hash de los archivos publicados mientras no
+// cambian su inodo, tamaño ni fechas, para no releerlos en cada stat.
+var resumenesArchivos = nuevoCacheResumenes(maxResumenesArchivos)
+
+func (n *Network) GetRemoteStatX(dest peer.ID, path string) (FileStat, error) {
+	var stat FileStat
+	s, err := n.Host.NewStream(context.Background(), dest, global.ProtocolFileSystemStatX)
+	if err != nil {
+		log.Error("Error al abrir stream: ", err)
+		return stat, err
+	}
+	defer s.Close()
+
+	s.Write([]byte(path + "\n"))
+
+	reader := bufio.NewReader(s)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return stat, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "-1" {
+		return stat, fmt.Errorf("archivo no encontrado: %s", path)
+	}
+	if strings.HasPrefix(line, "ERR:") {
+		return stat, fmt.Errorf("error del servidor: %s", line)
+	}
+	err = json.Unmarshal([]byte(line), &stat)
+	return stat, err
+}
+
+func (n *Network) ListarRemoto(dest peer.ID, path string) ([]FileEntry, error) {
+	s, err := n.Host.NewStream(context.Background(), dest, global.ProtocolFileSystemList)
+	if err != nil {
+		log.Error("Error al abrir stream: ", err)
+		return nil, err
+	}
+	defer s.Close()
+
+	s.Write([]byte(path + "\n"))
+
+	reader := bufio.NewReader(s)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "-1" || strings.HasPrefix(line, "ERR:") {
+		return nil, fmt.Errorf("error del servidor: %s", line)
+	}
+	var entradas []FileEntry
+	err = json.Unmarshal([]byte(line), &entradas)
+	return entradas, err
 }
 
 func (n *Network) GetRemoteStat(dest peer.ID, path string) (int64, error) {
@@ -121,12 +321,14 @@ func (n *Network) GetRemoteStat(dest peer.ID, path string) (int64, error) {
 	return size, nil
 }
 
-func (n *Network) RequestFile(dest peer.ID, remotePath string, localDest string) ([]byte, error) {
+// RequestFile descarga el archivo completo escribiéndolo en w a medida que
+// llega, sin retenerlo en memoria.
+func (n *Network) RequestFile(dest peer.ID, remotePath string, w io.Writer) (int64, error) {
 	log.Debug("Abrir stream con el protocolo")
 	s, err := n.Host.NewStream(context.Background(), dest, global.ProtocolFileSystem)
 	if err != nil {
 		log.Error("Error al abrir stream: ", err)
-		return nil, err
+		return 0, err
 	}
 	defer s.Close()
 
@@ -135,20 +337,18 @@ func (n *Network) RequestFile(dest peer.ID, remotePath string, localDest string)
 	status, err := reader.ReadString('\n')
 	if err != nil || !strings.HasPrefix(status, "OK") {
 		log.Error("Error del servidor: ", status)
-		return nil, fmt.Errorf("error del servidor: %s", status)
+		return 0, fmt.Errorf("error del servidor: %s", status)
 	}
 
-	var buf bytes.Buffer
-
 	fmt.Println("📥 Recibiendo datos...")
-	nBytes, err := io.Copy(&buf, s)
+	nBytes, err := io.Copy(w, reader)
 	if err != nil {
 		log.Error("Error al recibir el stream: ", err)
-		return nil, err
+		return nBytes, err
 	}
 
-	fmt.Printf("✅ Descarga completada: %d bytes guardados en %s\n", nBytes, localDest)
-	return buf.Bytes(), nil
+	fmt.Printf("✅ Descarga completada: %d bytes de %s\n", nBytes, remotePath)
+	return nBytes, nil
 }
 
 func (n *Network) FileSystem() {
@@ -181,78 +381,201 @@ func (f *FS) Root() (fs.Node, error) {
 	return &Dir{N: f.N}, nil
 }
 
+// Dir es la raíz, con los FILE remotos, o un directorio dentro de uno de
+// ellos. Path es el nombre remoto del directorio y Target el proveedor que lo
+// publica; queda vacío sin conexión.
 type Dir struct {
-	N *Network
+	N      *Network
+	Path   string
+	Target peer.ID
+	Stat   FileStat
+
+	mu       sync.Mutex
+	entradas []FileEntry
+	indice   map[string]FileEntry
+	listado  time.Time
+}
+
+const vigenciaListado = 5 * time.Second
+
+// listar devuelve las entradas del directorio remoto. El listado se reutiliza
+// unos segundos: Lookup arma los nodos con sus atributos en lugar de pedir un
+// stat por entrada.
+func (d *Dir) listar() ([]FileEntry, map[string]FileEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.indice != nil && time.Since(d.listado) < vigenciaListado {
+		return d.entradas, d.indice, nil
+	}
+	entradas, err := d.N.ListarRemoto(d.Target, d.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	indice := make(map[string]FileEntry, len(entradas))
+	for _, e := range entradas {
+		if nombreValido(e.Name) {
+			indice[e.Name] = e
+		}
+	}
+	d.entradas, d.indice, d.listado = entradas, indice, time.Now()
+	return entradas, indice, nil
 }
 
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = os.ModeDir | 0555 // Solo lectura para este ejemplo
+	a.Mtime = d.Stat.ModTime
 	return nil
 }
 
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	f := []fuse.Dirent{}
-	for _, resource := range d.N.RemoteResources.FILE {
-		f = append(f, fuse.Dirent{Name: resource.Name, Type: fuse.DT_File})
+	if d.Path == "" {
+		for _, resource := range d.N.RemoteResources.FILE {
+			f = append(f, fuse.Dirent{Name: resource.Name, Type: fuse.DT_Unknown})
+		}
+		return f, nil
 	}
 
-	return f, nil
+	if d.Target != "" {
+		entradas, _, err := d.listar()
+		if err == nil {
+			for _, e := range entradas {
+				if !nombreValido(e.Name) {
+					continue
+				}
+				tipo := fuse.DT_File
+				if e.Dir {
+					tipo = fuse.DT_Dir
+				}
+				f = append(f, fuse.Dirent{Name: e.Name, Type: tipo})
+			}
+			return f, nil
+		}
+		log.Error("Error al listar el directorio: ", err)
+	}
+	if entradas, ok := d.N.FileCache.listarSinConexion(d.Path); ok {
+		log.Warn("Usando el listado local de: ", d.Path)
+		return entradas, nil
+	}
+	return nil, fuse.EIO
 }
 
 func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if d.Path != "" {
+		if !nombreValido(name) {
+			return nil, fuse.ENOENT
+		}
+		nombre := d.Path + "/" + name
+		if d.Target != "" {
+			_, indice, err := d.listar()
+			if err == nil {
+				e, ok := indice[name]
+				if !ok {
+					return nil, fuse.ENOENT
+				}
+				return d.N.nodoEntrada(d.Target, nombre, FileStat{Size: e.Size, ModTime: e.ModTime, Dir: e.Dir}), nil
+			}
+			log.Error("Error al listar el directorio: ", err)
+		}
+		return d.N.nodoRemoto("", nombre)
+	}
 	for _, resource := range d.N.RemoteResources.FILE {
 		if name == resource.Name {
-
 			targetID := d.N.BuscarServicio(context.Background(), resource.Name)
 			if targetID == "" {
 				log.Error("Servicio no encontrado")
-				return nil, fuse.ENOENT
 			}
+			return d.N.nodoRemoto(targetID, name)
+		}
+	}
+	return nil, fuse.ENOENT
+}
 
-			log.Debug("Buscando Stat del archivo: ", name)
-			remoteSize, err := d.N.GetRemoteStat(targetID, name)
-			if err != nil {
-				log.Error("Error al obtener el stat del archivo: ", err)
-				return nil, fuse.ENOENT
-			}
-			log.Debug("Stat del archivo: ", remoteSize)
-			return &File{N: d.N, FileName: name, Size: uint64(remoteSize)}, nil
+func nombreValido(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// nodoRemoto arma el nodo de un nombre remoto según su stat. Sin proveedor,
+// usa lo que recuerda la caché de archivos en modo offline.
+func (n *Network) nodoRemoto(targetID peer.ID, name string) (fs.Node, error) {
+	cache := n.FileCache
+	if targetID != "" {
+		log.Debug("Buscando Stat del archivo: ", name)
+		stat, err := n.GetRemoteStatX(targetID, name)
+		if err == nil {
+			log.Debug("Stat del archivo: ", stat.Size)
+			return n.nodoEntrada(targetID, name, stat), nil
 		}
+		log.Error("Error al obtener el stat del archivo: ", err)
+	}
+	if stat, ok := cache.sinConexion(name); ok {
+		log.Warn("Usando la copia local de: ", name)
+		return &File{N: n, FileName: name, Size: uint64(stat.Size), Stat: stat}, nil
+	}
+	if _, ok := cache.listarSinConexion(name); ok {
+		return &Dir{N: n, Path: name}, nil
 	}
 	return nil, fuse.ENOENT
 }
 
+func (n *Network) nodoEntrada(targetID peer.ID, name string, stat FileStat) fs.Node {
+	if stat.Dir {
+		return &Dir{N: n, Path: name, Target: targetID, Stat: stat}
+	}
+	return &File{N: n, FileName: name, Size: uint64(stat.Size), Stat: stat, Target: targetID}
+}
+
+// File es un archivo remoto. Target queda vacío cuando se leyó el stat de la
+// caché porque el proveedor no estaba disponible.
 type File struct {
 	N        *Network
 	FileName string
-	Content  []byte
 	Size     uint64
+	Stat     FileStat
+	Target   peer.ID
 }
 
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	resp.Flags |= fuse.OpenDirectIO
-	return f, nil
-}
-
-func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	a.Mode = 0444
-	a.Size = f.Size
-	return nil
-}
-
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	log.Debug("Leyendo archivo...")
-	fmt.Printf("🚀 Iniciando descarga P2P para: %s...\n", f.FileName)
-
-	targetID := f.N.BuscarServicio(context.Background(), f.FileName)
-	if targetID == "" {
-		log.Error("Servicio no encontrado")
+	cache := f.N.FileCache
+	if f.Target == "" {
+		if local, ok := cache.abrir(f.Stat.SHA256); ok {
+			log.Debug("Leyendo desde la caché: ", f.FileName)
+			return &archivoLocal{f: local}, nil
+		}
 		return nil, fuse.ENOENT
 	}
-	content, err := f.N.RequestFile(targetID, f.FileName, f.FileName)
+	if cache != nil {
+		// El stat del listado no trae el hash; el del stat extendido dice
+		// qué contenido hay ahora en el proveedor.
+		stat, err := f.N.GetRemoteStatX(f.Target, f.FileName)
+		if err != nil {
+			log.Error("Error al obtener el stat del archivo: ", err)
+		} else {
+			f.Stat = stat
+			f.Size = uint64(stat.Size)
+			if local, ok := cache.abrirVigente(f.FileName, stat); ok {
+				log.Debug("Leyendo desde la caché: ", f.FileName)
+				return &archivoLocal{f: local}, nil
+			}
+		}
+	}
+	remoto, err := f.N.abrirArchivoRemoto(f.Target, f.FileName)
 	if err != nil {
+		log.Error("Error al abrir el archivo remoto: ", err)
 		return nil, fuse.ENOENT
 	}
+	f.Size = uint64(remoto.size)
+	var relleno *rellenoCache
+	if remoto.size == f.Stat.Size {
+		relleno = cache.preparar(f.N, f.Target, f.FileName, f.Stat)
+	}
+	return nuevoFileHandle(remoto, relleno), nil
+}
 
-	return content, nil
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Size = f.Size
+	a.Mtime = f.Stat.ModTime
+	return nil
 }
@@ -0,0 +1,345 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// Tipos de frame del proxy gRPC, viajan en el campo Extra del Envelop.
+const (
+	grpcHeaders  = "headers"
+	grpcData     = "data"
+	grpcTrailers = "trailers"
+	grpcFin      = "fin"
+)
+
+// Códigos de estado gRPC usados cuando la llamada no llega al servicio.
+const (
+	grpcInvalidArgument   = "3"
+	grpcDeadlineExceeded  = "4"
+	grpcPermissionDenied  = "7"
+	grpcResourceExhausted = "8"
+	grpcUnavailable       = "14"
+)
+
+// metodoGRPC es la forma de la ruta de un método: /paquete.Servicio/Metodo.
+var metodoGRPC = regexp.MustCompile(`^/[A-Za-z0-9_.]+/[A-Za-z0-9_]+$`)
+
+var grpcTransport = func() *http.Transport {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{Protocols: &protocols}
+}()
+
+func (n *Network) handleGRPCProxyStream(s network.Stream) {
+	defer s.Close()
+	remotePeer := s.Conn().RemotePeer()
+
+	if !RBAC.HasPermition2Protocol(remotePeer, n.Name, global.ProtocolGRPCProxy) {
+		log.Debug("Denegado, sin permiso al protocolo: ", remotePeer.String(), n.Name, global.ProtocolGRPCProxy)
+		s.Reset()
+		return
+	}
+
+	br := bufio.NewReader(s)
+	msg, err := leerEnvelop(br)
+	if err != nil {
+		log.Printf("Error leyendo stream: %v", err)
+		return
+	}
+	metodo := msg.Extra
+	if !metodoGRPC.MatchString(metodo) {
+		log.Debug("Método gRPC inválido: ", remotePeer.String(), " ", metodo)
+		enviarErrorGRPC(s, msg.Id, grpcInvalidArgument, "método inválido")
+		return
+	}
+
+	// Un permiso sobre el servicio habilita todos sus métodos; para restringir
+	// se otorgan permisos por método con la forma "servicio/paquete.Servicio/Metodo".
+	if !RBAC.Allowed(remotePeer, n.Name, global.ProtocolGRPCProxy, msg.Service) &&
+		!RBAC.Allowed(remotePeer, n.Name, global.ProtocolGRPCProxy, msg.Service+metodo) {
+		log.Debug("Denegado, sin permiso al método: ", remotePeer.String(), n.Name, msg.Service, metodo)
+		enviarErrorGRPC(s, msg.Id, grpcPermissionDenied, "sin permiso al método "+metodo)
+		return
+	}
+
+	resource := n.recursoAPI(msg.Service)
+	destino := resource.ResourcePath
+	if resource.Type != global.ResourceTypeGRPC || destino == "" {
+		enviarErrorGRPC(s, msg.Id, grpcUnavailable, "servicio no encontrado")
+		return
+	}
+	if ok, espera := n.permitirCuota(remotePeer, resource); !ok {
+		log.Warn("Cuota excedida por ", RBAC.Entity(remotePeer), " en ", msg.Service)
+		enviarErrorGRPC(s, msg.Id, grpcResourceExhausted, fmt.Sprintf("cuota excedida, reintentar en %d s", segundosReintento(espera)))
+		return
+	}
+	s = n.medirCuota(s, remotePeer, resource)
+	cb := n.breaker(resource)
+	if err := cb.Entrar(); err != nil {
+		log.Warn("Llamada gRPC rechazada a ", msg.Service, ": ", err)
+		enviarErrorGRPC(s, msg.Id, grpcUnavailable, err.Error())
+		return
+	}
+	if !strings.Contains(destino, "://") {
+		destino = "http://" + destino
+	}
+	base, err := url.Parse(destino)
+	if err != nil {
+		cb.Salir(false)
+		enviarErrorGRPC(s, msg.Id, grpcUnavailable, "destino inválido")
+		return
+	}
+
+	var header http.Header
+	if err := json.Unmarshal(msg.Payload, &header); err != nil {
+		log.Printf("Error decodificando metadata: %v", err)
+		cb.Salir(true)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			frame, err := leerEnvelop(br)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			switch frame.Extra {
+			case grpcData:
+				if _, err := pw.Write(frame.Payload); err != nil {
+					return
+				}
+			case grpcFin:
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	// El plazo corre hasta el primer mensaje de la respuesta: acota las
+	// llamadas unarias sin cortar las de streaming ya iniciadas.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var vencido atomic.Bool
+	limite := time.AfterFunc(cb.Timeout(), func() {
+		vencido.Store(true)
+		cancel()
+	})
+	defer limite.Stop()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.JoinPath(metodo).String(), pr)
+	if err != nil {
+		cb.Salir(false)
+		enviarErrorGRPC(s, msg.Id, grpcUnavailable, err.Error())
+		return
+	}
+	req.Header = header
+	fmt.Printf("📩 gRPC de %s: %s\n", remotePeer.String()[:6], metodo)
+
+	resp, err := grpcTransport.RoundTrip(req)
+	if err != nil {
+		log.Printf("Error replicando el llamado: %v", err)
+		cb.Salir(false)
+		enviarErrorGRPC(s, msg.Id, estadoFalloGRPC(vencido.Load()), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	exito := resp.StatusCode < http.StatusInternalServerError
+	defer func() { cb.Salir(exito) }()
+
+	meta, _ := json.Marshal(APIProxyMeta{Status: resp.StatusCode, Header: resp.Header})
+	if err := enviarFrameGRPC(s, msg.Id, grpcHeaders, meta); err != nil {
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		nr, err := resp.Body.Read(buf)
+		if nr > 0 {
+			limite.Stop()
+			if err := enviarFrameGRPC(s, msg.Id, grpcData, buf[:nr]); err != nil {
+				log.Debug("El consumidor cerró la llamada gRPC: ", err)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error leyendo respuesta gRPC: %v", err)
+			exito = false
+			if vencido.Load() {
+				trailers, _ := json.Marshal(http.Header{"Grpc-Status": {grpcDeadlineExceeded}, "Grpc-Message": {"plazo excedido"}})
+				enviarFrameGRPC(s, msg.Id, grpcTrailers, trailers)
+			}
+			return
+		}
+	}
+	limite.Stop()
+
+	trailers, _ := json.Marshal(resp.Trailer)
+	enviarFrameGRPC(s, msg.Id, grpcTrailers, trailers)
+}
+
+// ConversarGRPC lleva una llamada gRPC recibida localmente (HTTP/2) hasta el
+// proveedor y devuelve encabezados, mensajes y trailers a medida que llegan,
+// de modo que funcionan las llamadas unarias y las de streaming.
+func (n *Network) ConversarGRPC(targetID peer.ID, service string, w http.ResponseWriter, r *http.Request) error {
+	s, err := n.Host.NewStream(r.Context(), targetID, global.ProtocolGRPCProxy)
+	if err != nil {
+		return fmt.Errorf("error abriendo stream: %w", err)
+	}
+	defer s.Close()
+
+	id := uuid.New().String()
+	header, _ := json.Marshal(r.Header)
+	msg := &global.Envelop{
+		Id:      id,
+		Service: service,
+		Extra:   r.URL.Path,
+		Payload: header,
+	}
+	data, _ := proto.Marshal(msg)
+	if _, err := writeDelimited(s, data); err != nil {
+		return fmt.Errorf("error enviando llamada: %w", err)
+	}
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			nr, err := r.Body.Read(buf)
+			if nr > 0 {
+				if enviarFrameGRPC(s, id, grpcData, buf[:nr]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				enviarFrameGRPC(s, id, grpcFin, nil)
+				return
+			}
+		}
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	br := bufio.NewReader(s)
+	for {
+		frame, err := leerEnvelop(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error leyendo respuesta: %w", err)
+		}
+
+		switch frame.Extra {
+		case grpcHeaders:
+			var meta APIProxyMeta
+			if err := json.Unmarshal(frame.Payload, &meta); err != nil {
+				return fmt.Errorf("error decodificando encabezados: %w", err)
+			}
+			for k, v := range meta.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(meta.Status)
+		case grpcData:
+			if _, err := w.Write(frame.Payload); err != nil {
+				return nil
+			}
+		case grpcTrailers:
+			var trailers http.Header
+			if err := json.Unmarshal(frame.Payload, &trailers); err != nil {
+				return fmt.Errorf("error decodificando trailers: %w", err)
+			}
+			for k, v := range trailers {
+				w.Header()[http.TrailerPrefix+k] = v
+			}
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// enviarErrorGRPC responde con un "trailers-only": los encabezados ya llevan
+// grpc-status, que es como los servidores gRPC informan un error inmediato.
+func enviarErrorGRPC(s network.Stream, id string, status string, mensaje string) {
+	meta, _ := json.Marshal(APIProxyMeta{
+		Status: http.StatusOK,
+		Header: http.Header{
+			"Content-Type": {"application/grpc"},
+			"Grpc-Status":  {status},
+			"Grpc-Message": {mensaje},
+		},
+	})
+	enviarFrameGRPC(s, id, grpcHeaders, meta)
+	enviarFrameGRPC(s, id, grpcTrailers, []byte("{}"))
+}
+
+// estadoFalloGRPC distingue un plazo vencido de un servicio inalcanzable.
+func estadoFalloGRPC(vencido bool) string {
+	if vencido {
+		return grpcDeadlineExceeded
+	}
+	return grpcUnavailable
+}
+
+func enviarFrameGRPC(s network.Stream, id string, tipo string, payload []byte) error {
+	out, _ := proto.Marshal(&global.Envelop{Id: id, Extra: tipo, Payload: payload})
+	_, err := writeDelimited(s, out)
+	return err
+}
+
+func leerEnvelop(br *bufio.Reader) (*global.Envelop, error) {
+	data, err := readDelimited(br)
+	if err != nil {
+		return nil, err
+	}
+	msg := &global.Envelop{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("error unmarshal protobuf: %w", err)
+	}
+	return msg, nil
+}
@@ -31,6 +31,7 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -45,18 +46,68 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// QueryError viaja en el cierre de una consulta fallida, o con Extra "error"
+// en los protocolos de una sola respuesta.
+type QueryError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+func (qe *QueryError) Error() string {
+	return qe.Code + ": " + qe.Message
+}
+
+const (
+	frameError = "error"
+
+	errQuotaExceeded     = "QUOTA_EXCEEDED"
+	errQueryFailed       = "QUERY_FAILED"
+	errUnsupportedFormat = "UNSUPPORTED_FORMAT"
+	errQueryRejected     = "QUERY_REJECTED"
+
+	bloqueDefecto = 1000
+)
+
+// Formatos de resultado y su tipo de contenido.
+var FormatosQuery = map[string]string{
+	"arrow":   "application/vnd.apache.arrow.stream",
+	"csv":     "text/csv",
+	"json":    "application/json",
+	"ndjson":  "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
 type QueryType struct {
-	Query     string `json:"query"`
-	Format    string `json:"format"`
-	FileName  string `json:"file_name"`
+	Query  string `json:"query"`
+	Format string `json:"format"`
+	// FileName, si se indica, guarda el resultado en el nodo local en vez de
+	// devolverlo al cliente HTTP.
+	FileName  string `json:"file_name,omitempty"`
 	BlockSize int    `json:"block_size"`
+	// Template invoca una plantilla publicada en lugar de Query.
+	Template string                 `json:"template,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+	// Cursor guarda el resultado en el proveedor para leerlo por páginas.
+	// CursorID o PageToken piden una página de un cursor abierto; Offset y
+	// Limit la delimitan (Limit 0 es hasta el final).
+	Cursor    bool   `json:"cursor,omitempty"`
+	CursorID  string `json:"cursor_id,omitempty"`
+	PageToken string `json:"page_token,omitempty"`
+	Offset    int64  `json:"offset,omitempty"`
+	Limit     int64  `json:"limit,omitempty"`
+
+	// sinCabecera omite la fila de nombres en CSV, al continuar un resultado.
+	sinCabecera bool
 }
 
 func StringToQueryType(jsonStr string) (*QueryType, error) {
 	var req QueryType
 
 	fmt.Println("jsonStr -> StringToQueryType -> ", jsonStr)
-	err := json.Unmarshal([]byte(jsonStr), &req)
+	dec := json.NewDecoder(strings.NewReader(jsonStr))
+	dec.UseNumber()
+	err := dec.Decode(&req)
 	if err != nil {
 		return nil, err
 	}
@@ -77,13 +128,6 @@ func (n *Network) HandleSearch(s network.Stream) {
 	defer s.Close()
 
 	log.Debug("HandleSearch")
-	db, err := sql.Open("duckdb", "")
-	if err != nil {
-		log.Error("Error al abrir la base de datos: ", err)
-		return
-	}
-	defer db.Close()
-
 	msg := &global.Envelop{}
 	data, err := readDelimited(s)
 	if err != nil {
@@ -103,118 +147,291 @@ func (n *Network) HandleSearch(s network.Stream) {
 		log.Error("Error al convertir el payload a QueryType: ", err)
 		return
 	}
+	e := nuevoEmisor(s, msg.Id, queryType.Format)
+	if _, ok := FormatosQuery[queryType.Format]; !ok {
+		e.fallar(QueryError{Code: errUnsupportedFormat, Message: "formato no soportado: " + queryType.Format})
+		return
+	}
+	if queryType.BlockSize <= 0 {
+		queryType.BlockSize = bloqueDefecto
+	}
 
-	for _, ds := range n.Resources.DATASOURCE {
-		if ds.Name == msg.Service {
-			queryType.FileName = ds.ResourcePath
+	var ds *global.ResourceType
+	for i := range n.Resources.DATASOURCE {
+		if n.Resources.DATASOURCE[i].Name == msg.Service {
+			ds = &n.Resources.DATASOURCE[i]
+			break
+		}
+	}
+	// Sin entidad registrada o sin permiso la fuente no existe para el peer,
+	// igual que en el esquema y las suscripciones.
+	remotePeer := s.Conn().RemotePeer()
+	if ds == nil || RBAC.Entity(remotePeer) == "" || !RBAC.Allowed(remotePeer, n.Name, global.ProtocolQuery, ds.Name) {
+		e.fallar(QueryError{Code: errDataSourceNotFound, Message: "fuente de datos no encontrada: " + msg.Service})
+		return
+	}
 
-			query := strings.ReplaceAll(queryType.Query, "{{ORIGIN}}", ds.ResourcePath)
-			log.Debug("Query: ", query)
-			if queryType.Format == "parquet" {
-				file, err := os.CreateTemp("", "parquet-export-*.parquet")
-				if err != nil {
-					log.Errorf("Error creando archivo temporal: %v", err)
-					return
-				}
-				fileName := file.Name()
-				file.Close()
-				defer os.Remove(fileName)
-
-				fmt.Println("query: ", query)
-				fmt.Println("format: ", queryType.Format)
-				fmt.Println("fileName: ", fileName)
-				exportToParquet(db, query, fileName)
-				TransferFile(s, fileName)
-				return
-			}
+	if ok, espera := n.permitirCuota(s.Conn().RemotePeer(), *ds); !ok {
+		log.Warn("Cuota excedida por ", RBAC.Entity(s.Conn().RemotePeer()), " en ", ds.Name)
+		e.fallar(QueryError{Code: errQuotaExceeded, Message: "cuota excedida", RetryAfter: segundosReintento(espera)})
+		return
+	}
+	e.s = n.medirCuota(e.s, s.Conn().RemotePeer(), *ds)
 
-			rows, err := db.Query(query)
-			if err != nil {
-				log.Error("Error ejecutando consulta: %v", err)
-				return
-			}
-			defer rows.Close()
+	db, err := abrirSandboxLimitado(ds.Limits)
+	if err != nil {
+		log.Error("Error al abrir la base de datos: ", err)
+		e.fallar(QueryError{Code: errQueryFailed, Message: "no se pudo preparar la consulta"})
+		return
+	}
+	defer db.Close()
+	ctx, cancel := contextoConsulta(s, ds.Limits)
+	defer cancel()
 
-			cols, _ := rows.Columns()
+	if queryType.CursorID != "" || queryType.PageToken != "" {
+		n.paginaCursor(ctx, db, e, *ds, s.Conn().RemotePeer(), queryType)
+		return
+	}
 
-			var batch []map[string]interface{}
-			var batchCsv [][]string
+	politica := politicaPara(*ds, RBAC.Entity(s.Conn().RemotePeer()))
+	origen, vistas, archivos, err := prepararOrigen(db, *ds, politica)
+	if err != nil {
+		log.Error(err)
+		e.fallar(QueryError{Code: errQueryFailed, Message: "no se pudo preparar la fuente de datos"})
+		return
+	}
 
-			if queryType.Format == "csv" {
-				sendCsvBatch(s, [][]string{cols}, false)
-			}
+	consulta, args, qe := consultaSolicitada(*ds, queryType)
+	if qe != nil {
+		e.fallar(*qe)
+		return
+	}
+	query := strings.ReplaceAll(consulta, "{{ORIGIN}}", origen)
+	log.Debug("Query: ", query)
+	if err := validarConsulta(db, query, vistas, archivos); err != nil {
+		log.Warn("Consulta rechazada de ", RBAC.Entity(s.Conn().RemotePeer()), " en ", ds.Name, ": ", err)
+		e.fallar(QueryError{Code: errQueryRejected, Message: err.Error()})
+		return
+	}
 
-			columns := make([]interface{}, len(cols))
-			columnPointers := make([]interface{}, len(cols))
-			for i := range columns {
-				columnPointers[i] = &columns[i]
-			}
+	var clave string
+	cache := n.cacheResultados(*ds)
+	if cache != nil && !queryType.Cursor {
+		clave = claveResultado(db, *ds, politica, query, args, queryType)
+	}
+	if clave != "" {
+		if r := cache.obtener(clave); r != nil {
+			log.Debug("Resultado en caché para ", ds.Name)
+			e.enviarCacheado(r)
+			return
+		}
+	}
 
-			count := 0
-			for rows.Next() {
-				count++
-				if err := rows.Scan(columnPointers...); err != nil {
-					log.Error("Error escaneando fila: %v", err)
-					break
-				}
+	columnas, err := columnasConsulta(ctx, db, query, args)
+	if err != nil {
+		log.Errorf("Error ejecutando consulta: %v", err)
+		e.fallar(errorEjecucion(ctx, err))
+		return
+	}
+	if queryType.Cursor {
+		c, err := n.abrirCursor(ctx, db, *ds, s.Conn().RemotePeer(), query, args, columnas)
+		if err != nil {
+			log.Errorf("Error abriendo cursor: %v", err)
+			e.fallar(errorEjecucion(ctx, err))
+			return
+		}
+		n.enviarPagina(ctx, db, e, *ds, c, queryType.Offset, queryType)
+		return
+	}
+	if err := e.enviarCabecera(columnas); err != nil {
+		log.Error("Error enviando cabecera: ", err)
+		return
+	}
 
-				if queryType.Format == "json" || queryType.Format == "parquet" {
-					m := make(map[string]interface{})
-					for i, colName := range cols {
-						val := columns[i]
-						if b, ok := val.([]byte); ok {
-							m[colName] = string(b)
-						} else {
-							m[colName] = val
-						}
-					}
-					batch = append(batch, m)
-				} else if queryType.Format == "csv" {
-					fila := make([]string, len(cols))
-					for i, val := range columns {
-						if val == nil {
-							fila[i] = ""
-						} else {
-							fila[i] = fmt.Sprint(val)
-						}
-					}
-					batchCsv = append(batchCsv, fila)
-				}
+	var w io.Writer = escritorConLimite(e, ds.Limits)
+	var grabador *grabadorResultado
+	if clave != "" {
+		grabador = &grabadorResultado{w: w, max: cache.maxBytes}
+		w = grabador
+	}
+	filas, err := enviarResultado(ctx, db, w, query, args, queryType, limiteFilas(ds.Limits), nil)
+	if err != nil {
+		log.Errorf("Error ejecutando consulta: %v", err)
+		e.fallar(errorEjecucion(ctx, err))
+		return
+	}
+	if grabador != nil && !grabador.desbordado {
+		cache.guardar(&resultadoCache{clave: clave, columnas: columnas, bloques: grabador.bloques, filas: filas, bytes: grabador.bytes})
+	}
+	e.terminar(filas)
+}
+
+// ejecutorSQL es una base o una conexión fija de ella. Las tablas temporales
+// de DuckDB son de cada conexión: quien las usa pasa la conexión.
+type ejecutorSQL interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// enviarResultado ejecuta la consulta y escribe el resultado en w, en bloques
+// de BlockSize filas. Devuelve el total de filas. Con maxFilas > 0, un
+// resultado más largo termina en error. En los formatos de texto, confirmar
+// recibe las filas enviadas después de cada bloque.
+func enviarResultado(ctx context.Context, db ejecutorSQL, w io.Writer, query string, args []interface{}, queryType *QueryType, maxFilas int64, confirmar func(int64) error) (int64, error) {
+	if maxFilas > 0 {
+		// Basta una fila más que el máximo para saber que se excede.
+		query = fmt.Sprintf("SELECT * FROM (%s\n) LIMIT %d", query, maxFilas+1)
+	}
+
+	if queryType.Format == "parquet" {
+		file, err := os.CreateTemp("", "parquet-export-*.parquet")
+		if err != nil {
+			return 0, fmt.Errorf("error creando archivo temporal: %w", err)
+		}
+		fileName := file.Name()
+		file.Close()
+		defer os.Remove(fileName)
+
+		filas, err := exportToParquet(ctx, db, query, fileName, args...)
+		if err != nil {
+			return 0, err
+		}
+		if maxFilas > 0 && filas > maxFilas {
+			return 0, excesoFilas(maxFilas)
+		}
+		return filas, TransferFile(w, fileName)
+	}
+
+	if queryType.Format == "arrow" {
+		return enviarArrow(ctx, db, w, query, args, queryType.BlockSize, maxFilas)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, _ := rows.Columns()
+
+	var batch []map[string]interface{}
+	var batchCsv [][]string
+	var batchNd bytes.Buffer
+
+	if queryType.Format == "csv" && !queryType.sinCabecera {
+		if err := sendCsvBatch(w, [][]string{cols}, false); err != nil {
+			return 0, err
+		}
+	}
+
+	columns := make([]interface{}, len(cols))
+	columnPointers := make([]interface{}, len(cols))
+	for i := range columns {
+		columnPointers[i] = &columns[i]
+	}
+
+	var total int64
+	count := 0
+	for rows.Next() {
+		if maxFilas > 0 && total == maxFilas {
+			return total, excesoFilas(maxFilas)
+		}
+		count++
+		total++
+		if err := rows.Scan(columnPointers...); err != nil {
+			return total, fmt.Errorf("error escaneando fila: %w", err)
+		}
 
-				if count >= queryType.BlockSize {
-					if queryType.Format == "csv" {
-						log.Debug("filas: ", batchCsv)
-						sendCsvBatch(s, batchCsv, true)
-						batchCsv = nil
-					} else if queryType.Format == "json" {
-						sendJsonBatch(s, batch, false)
-						batch = nil
-					} else {
-						log.Error("Formato no soportado: ", queryType.Format)
-					}
-					count = 0
+		if queryType.Format == "json" || queryType.Format == "ndjson" {
+			m := make(map[string]interface{})
+			for i, colName := range cols {
+				val := columns[i]
+				if b, ok := val.([]byte); ok {
+					m[colName] = string(b)
+				} else {
+					m[colName] = val
 				}
 			}
+			if queryType.Format == "ndjson" {
+				linea, err := json.Marshal(m)
+				if err != nil {
+					return total, fmt.Errorf("error al codificar JSON: %w", err)
+				}
+				batchNd.Write(linea)
+				batchNd.WriteByte('\n')
+			} else {
+				batch = append(batch, m)
+			}
+		} else if queryType.Format == "csv" {
+			fila := make([]string, len(cols))
+			for i, val := range columns {
+				if val == nil {
+					fila[i] = ""
+				} else {
+					fila[i] = fmt.Sprint(val)
+				}
+			}
+			batchCsv = append(batchCsv, fila)
+		}
 
+		if count >= queryType.BlockSize {
 			if queryType.Format == "csv" {
-				sendCsvBatch(s, batchCsv, true)
+				log.Debug("filas: ", batchCsv)
+				err = sendCsvBatch(w, batchCsv, false)
+				batchCsv = nil
 			} else if queryType.Format == "json" {
-				sendJsonBatch(s, batch, true)
+				err = sendJsonBatch(w, batch, false)
+				batch = nil
+			} else if queryType.Format == "ndjson" {
+				err = sendNdjsonBatch(w, &batchNd)
 			}
-			break
+			if err != nil {
+				return total, err
+			}
+			if confirmar != nil {
+				if err := confirmar(total); err != nil {
+					return total, err
+				}
+			}
+			count = 0
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return total, err
+	}
+
+	if queryType.Format == "csv" {
+		err = sendCsvBatch(w, batchCsv, false)
+	} else if queryType.Format == "json" {
+		err = sendJsonBatch(w, batch, false)
+	} else if queryType.Format == "ndjson" {
+		err = sendNdjsonBatch(w, &batchNd)
+	}
+	if err == nil && count > 0 && confirmar != nil {
+		err = confirmar(total)
+	}
+	return total, err
+}
+
+func enviarErrorQuery(s network.Stream, qe QueryError) {
+	payload, _ := json.Marshal(qe)
+	resp := &global.Envelop{
+		Payload: payload,
+		Extra:   frameError,
+	}
+	out, _ := proto.Marshal(resp)
+	writeDelimited(s, out)
 }
 
-func exportToParquet(db *sql.DB, query string, outputFile string) error {
-	exportQuery := fmt.Sprintf("COPY (%s) TO '%s' (FORMAT PARQUET)", query, outputFile)
+func exportToParquet(ctx context.Context, db ejecutorSQL, query string, outputFile string, args ...interface{}) (int64, error) {
+	exportQuery := fmt.Sprintf("COPY (%s\n) TO '%s' (FORMAT PARQUET)", query, outputFile)
 
-	_, err := db.Exec(exportQuery)
+	res, err := db.ExecContext(ctx, exportQuery, args...)
 	if err != nil {
-		return fmt.Errorf("error exportando a parquet: %w", err)
+		return 0, fmt.Errorf("error exportando a parquet: %w", err)
 	}
 
-	return nil
+	return res.RowsAffected()
 }
 
 func scanRowToMap(rows *sql.Rows, cols []string) map[string]interface{} {
@@ -256,38 +473,39 @@ func scanRowToMap(rows *sql.Rows, cols []string) map[string]interface{} {
 	return rowMap
 }
 
-func sendJsonBatch(s network.Stream, batch []map[string]interface{}, isLast bool) {
+func sendJsonBatch(w io.Writer, batch []map[string]interface{}, isLast bool) error {
 	if len(batch) == 0 && !isLast {
-		return
+		return nil
 	}
 	jsonData, err := json.Marshal(batch)
 	if err != nil {
-		log.Error("Error al codificar JSON: %v", err)
-		return
+		return fmt.Errorf("error al codificar JSON: %w", err)
 	}
-	resp := &global.Envelop{
-		Payload: jsonData,
+	_, err = w.Write(jsonData)
+	return err
+}
+
+func sendNdjsonBatch(w io.Writer, buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		return nil
 	}
-	out, _ := proto.Marshal(resp)
-	writeDelimited(s, out)
+	_, err := w.Write(buf.Bytes())
+	buf.Reset()
+	return err
 }
 
-func sendCsvBatch(s network.Stream, batch [][]string, isLast bool) {
+func sendCsvBatch(w io.Writer, batch [][]string, isLast bool) error {
 	if len(batch) == 0 && !isLast {
-		return
+		return nil
 	}
 	var buf bytes.Buffer
 	writer := csv.NewWriter(&buf)
 	if err := writer.WriteAll(batch); err != nil {
-		log.Error("Error escribiendo CSV: %v", err)
-		return
+		return fmt.Errorf("error escribiendo CSV: %w", err)
 	}
 	writer.Flush()
-	resp := &global.Envelop{
-		Payload: buf.Bytes(),
-	}
-	out, _ := proto.Marshal(resp)
-	writeDelimited(s, out)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func sendBatch(s network.Stream, data []string, final bool) {
@@ -298,19 +516,44 @@ func sendBatch(s network.Stream, data []string, final bool) {
 	writeDelimited(s, out)
 }
 
-func (n *Network) Query(targetID peer.ID, query QueryType, service string) []byte {
+// Query envía la consulta al proveedor y escribe el resultado en w a medida
+// que llegan las tramas de datos. Los bloques JSON, que el proveedor envía
+// como arreglos independientes, se unen en un único arreglo. Si el proveedor
+// informa un error en el cierre se devuelve como *QueryError; si el stream
+// termina sin cierre, el resultado está incompleto. Al cancelarse ctx se
+// corta el stream y el proveedor deja de ejecutar la consulta.
+func (n *Network) Query(ctx context.Context, targetID peer.ID, query QueryType, service string, w io.Writer) (*QueryTrailer, error) {
+	return n.consultar(ctx, targetID, query, service, w, &lecturaQuery{})
+}
+
+// lecturaQuery es el estado de la lectura de un resultado. Se conserva al
+// retomar un cursor en otro stream, para seguir escribiendo sobre el mismo w.
+type lecturaQuery struct {
+	bloques    int
+	cursor     string
+	inicio     int64
+	total      int64
+	confirmado int64
+	// pendientes son los bloques de un cursor que todavía no tienen trama de
+	// avance; se escriben al confirmarse.
+	pendientes [][]byte
+}
 
-	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolQuery)
+func formatoReanudable(formato string) bool {
+	return formato == "csv" || formato == "json" || formato == "ndjson"
+}
+
+func (n *Network) consultar(ctx context.Context, targetID peer.ID, query QueryType, service string, w io.Writer, est *lecturaQuery) (*QueryTrailer, error) {
+	s, err := n.Host.NewStream(ctx, targetID, global.ProtocolQuery)
 	if err != nil {
-		log.Printf("Error abriendo stream: %v", err)
-		return nil
+		return nil, fmt.Errorf("error abriendo stream: %w", err)
 	}
 	defer s.Close()
+	defer context.AfterFunc(ctx, func() { s.Reset() })()
 
 	qt, err := json.Marshal(query)
 	if err != nil {
-		log.Error("Error al codificar JSON: %v", err)
-		return nil
+		return nil, fmt.Errorf("error al codificar JSON: %w", err)
 	}
 
 	msg := &global.Envelop{
@@ -319,94 +562,200 @@ func (n *Network) Query(targetID peer.ID, query QueryType, service string) []byt
 		Payload: qt,
 	}
 	data, _ := proto.Marshal(msg)
-	writeDelimited(s, data)
-
-	f, err := os.Create(query.FileName)
-	if err != nil {
-		log.Error("Error creando archivo: ", err)
-		return nil
+	if _, err := writeDelimited(s, data); err != nil {
+		return nil, fmt.Errorf("error enviando consulta: %w", err)
 	}
-	defer f.Close()
 
+	var cabecera QueryHeader
+	var esperado uint64
 	br := bufio.NewReader(s)
 	for {
-		protoData, err := readDelimited(br)
+		frame, err := leerEnvelop(br)
 		if err != nil {
 			if err == io.EOF {
-				break
+				return nil, fmt.Errorf("el proveedor cerró el stream sin terminar la consulta %s", msg.Id)
 			}
-			log.Error("Error leyendo proto delimitado: ", err)
-			return nil
+			return nil, fmt.Errorf("error leyendo trama: %w", err)
+		}
+		if frame.Id != msg.Id || frame.Seq != esperado {
+			return nil, fmt.Errorf("trama inesperada: id %s, seq %d (esperado %d)", frame.Id, frame.Seq, esperado)
+		}
+		esperado++
+
+		switch frame.Extra {
+		case frameHeader:
+			json.Unmarshal(frame.Payload, &cabecera)
+			log.Debugf("Consulta %s: %d columnas", cabecera.QueryID, len(cabecera.Columns))
+			if cabecera.CursorID != "" {
+				if est.cursor == "" {
+					est.inicio = cabecera.Offset
+				}
+				est.cursor, est.total, est.confirmado = cabecera.CursorID, cabecera.TotalRows, cabecera.Offset
+			}
+			est.pendientes = nil
+		case frameProgress:
+			var avance QueryProgress
+			json.Unmarshal(frame.Payload, &avance)
+			if err := est.escribirPendientes(w, query.Format); err != nil {
+				return nil, err
+			}
+			est.confirmado = avance.Offset
+		case frameTrailer:
+			cierre := &QueryTrailer{}
+			if err := json.Unmarshal(frame.Payload, cierre); err != nil {
+				return nil, fmt.Errorf("error decodificando cierre: %w", err)
+			}
+			if cierre.Status != estadoOK {
+				qe := cierre.Error
+				if qe == nil {
+					qe = &QueryError{Code: errQueryFailed, Message: "error desconocido"}
+				}
+				log.Errorf("Consulta rechazada por el proveedor: %s %s (reintentar en %d s)", qe.Code, qe.Message, qe.RetryAfter)
+				return cierre, qe
+			}
+			if err := est.escribirPendientes(w, query.Format); err != nil {
+				return cierre, err
+			}
+			if cierre.CursorID != "" {
+				est.confirmado = cabecera.Offset + cierre.Rows
+			}
+			if err := est.terminar(w, query.Format); err != nil {
+				return cierre, err
+			}
+			log.Infof("Consulta %s completada: %d filas en %d ms", cierre.QueryID, cierre.Rows, cierre.DurationMs)
+			return cierre, nil
+		case frameData:
+			log.Debugf("Batch de %d bytes recibido", len(frame.Payload))
+			if est.cursor != "" && formatoReanudable(query.Format) {
+				est.pendientes = append(est.pendientes, frame.Payload)
+				continue
+			}
+			if err := est.escribir(w, query.Format, frame.Payload); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("trama desconocida: %s", frame.Extra)
 		}
+	}
+}
 
-		if len(protoData) == 0 {
-			log.Debug("Fin de stream recibido (proto vacío)")
-			break
+func (est *lecturaQuery) terminar(w io.Writer, formato string) error {
+	if formato != "json" {
+		return nil
+	}
+	cierreJSON := "]"
+	if est.bloques == 0 {
+		cierreJSON = "[]"
+	}
+	_, err := io.WriteString(w, cierreJSON)
+	return err
+}
+
+func (est *lecturaQuery) escribirPendientes(w io.Writer, formato string) error {
+	for _, payload := range est.pendientes {
+		if err := est.escribir(w, formato, payload); err != nil {
+			return err
 		}
+	}
+	est.pendientes = nil
+	return nil
+}
 
-		var msg global.Envelop
-		if err := proto.Unmarshal(protoData, &msg); err != nil {
-			log.Error("Error deserializando proto: ", err)
+func (est *lecturaQuery) escribir(w io.Writer, formato string, payload []byte) error {
+	if formato == "json" {
+		payload = bytes.TrimSpace(payload)
+		payload = bytes.TrimSuffix(bytes.TrimPrefix(payload, []byte("[")), []byte("]"))
+		if len(payload) == 0 {
 			return nil
 		}
-
-		if msg.Payload == nil || len(msg.Payload) == 0 {
-			log.Debug("Fin de stream (payload vacío)")
-			break
+		separador := ","
+		if est.bloques == 0 {
+			separador = "["
+		}
+		if _, err := io.WriteString(w, separador); err != nil {
+			return err
 		}
+	}
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("error escribiendo resultado: %w", err)
+	}
+	est.bloques++
+	if flusher, ok := w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
 
-		if _, err := f.Write(msg.Payload); err != nil {
-			log.Error("Error escribiendo en disco: ", err)
-			return nil
+const maxReanudaciones = 3
+
+// QueryCursor es Query para consultas en modo cursor. Si el stream se corta,
+// pide al proveedor el resto desde la última fila confirmada y lo sigue
+// escribiendo en w; solo se puede en los formatos de texto.
+func (n *Network) QueryCursor(ctx context.Context, targetID peer.ID, query QueryType, service string, w io.Writer) (*QueryTrailer, error) {
+	est := &lecturaQuery{}
+	limite := query.Limit
+	for intento := 0; ; intento++ {
+		cierre, err := n.consultar(ctx, targetID, query, service, w, est)
+		var qe *QueryError
+		if err == nil || errors.As(err, &qe) || ctx.Err() != nil || est.cursor == "" || !formatoReanudable(query.Format) || intento == maxReanudaciones {
+			if cierre != nil && est.cursor != "" {
+				cierre.Rows = est.confirmado - est.inicio
+			}
+			return cierre, err
 		}
 
-		log.Debugf("Batch de %d bytes guardado", len(msg.Payload))
+		log.Warnf("Stream cortado en el cursor %s, se retoma desde la fila %d: %v", est.cursor, est.confirmado, err)
+		query.Cursor, query.PageToken = false, ""
+		query.CursorID, query.Offset = est.cursor, est.confirmado
+		if limite > 0 {
+			query.Limit = est.inicio + limite - est.confirmado
+			if query.Limit == 0 {
+				// La página ya llegó completa; solo faltó el cierre.
+				return est.cierreLocal(), est.terminar(w, query.Format)
+			}
+		}
 	}
-
-	log.Info("Transferencia completada con éxito")
-	return nil
 }
 
-func TransferFile(s network.Stream, fileName string) {
+func (est *lecturaQuery) cierreLocal() *QueryTrailer {
+	cierre := &QueryTrailer{Status: estadoOK, Rows: est.confirmado - est.inicio, CursorID: est.cursor}
+	if est.confirmado < est.total {
+		cierre.NextOffset = est.confirmado
+		cierre.NextToken = tokenPagina(est.cursor, est.confirmado)
+	}
+	return cierre
+}
 
+func TransferFile(w io.Writer, fileName string) error {
 	fileToStream, err := os.Open(fileName)
 	if err != nil {
-		log.Errorf("Error abriendo para stream: %v", err)
-		return
+		return fmt.Errorf("error abriendo para stream: %w", err)
 	}
-	info, _ := os.Stat(fileName)
+	defer fileToStream.Close()
+	info, _ := fileToStream.Stat()
 	log.Debugf("Tamaño real del archivo en disco: %d bytes", info.Size())
 
 	buffer := make([]byte, 64*1024)
 
 	log.Debug("Iniciando transmisión de bytes...")
-	totalEnviado := 0
 	for {
 		n, err := fileToStream.Read(buffer)
 		if n > 0 {
-			resp := &global.Envelop{
-				Payload: buffer[:n],
-			}
-			out, _ := proto.Marshal(resp)
-
-			if _, err := writeDelimited(s, out); err != nil {
-				log.Errorf("Error enviando chunk: %v", err)
-				return
+			if _, err := w.Write(buffer[:n]); err != nil {
+				return fmt.Errorf("error enviando chunk: %w", err)
 			}
-			totalEnviado += n
 		}
 
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Errorf("Error leyendo archivo: %v", err)
-			break
+			return fmt.Errorf("error leyendo archivo: %w", err)
 		}
 	}
-	fileToStream.Close()
 
 	log.Info("Transmisión Parquet completada con éxito")
+	return nil
 }
 
 type MiMemFile struct {
@@ -0,0 +1,202 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const errDataSourceNotFound = "NOT_FOUND"
+
+type ColumnSchema struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	// Masked indica si la política de acceso transforma la columna.
+	Masked string `json:"masked,omitempty"`
+}
+
+type DataSourceSchema struct {
+	Name         string           `json:"name"`
+	Description  string           `json:"description,omitempty"`
+	RowsEstimate *int64           `json:"rows_estimate,omitempty"`
+	Columns      []ColumnSchema   `json:"columns"`
+	FreeSQL      bool             `json:"free_sql"`
+	Templates    []TemplateSchema `json:"templates,omitempty"`
+}
+
+// handleQuerySchema describe los DATA_SOURCE que el llamador puede consultar,
+// tal como los ve a través de su política. Sin Service se describen todos.
+func (n *Network) handleQuerySchema(s network.Stream) {
+	defer s.Close()
+	remotePeer := s.Conn().RemotePeer()
+
+	msg, err := leerEnvelop(bufio.NewReader(s))
+	if err != nil {
+		log.Error("Error leyendo solicitud de esquema: ", err)
+		return
+	}
+
+	var esquemas []DataSourceSchema
+	for _, ds := range n.Resources.DATASOURCE {
+		if msg.Service != "" && ds.Name != msg.Service {
+			continue
+		}
+		if !RBAC.Allowed(remotePeer, n.Name, global.ProtocolQuery, ds.Name) {
+			continue
+		}
+		esquema, err := describirFuente(ds, politicaPara(ds, RBAC.Entity(remotePeer)))
+		if err != nil {
+			log.Error("Error describiendo ", ds.Name, ": ", err)
+			enviarErrorQuery(s, QueryError{Code: errQueryFailed, Message: "no se pudo describir " + ds.Name})
+			return
+		}
+		esquemas = append(esquemas, esquema)
+	}
+	if msg.Service != "" && len(esquemas) == 0 {
+		enviarErrorQuery(s, QueryError{Code: errDataSourceNotFound, Message: "fuente de datos no encontrada: " + msg.Service})
+		return
+	}
+
+	payload, _ := json.Marshal(esquemas)
+	out, _ := proto.Marshal(&global.Envelop{Id: msg.Id, Payload: payload})
+	writeDelimited(s, out)
+}
+
+func describirFuente(ds global.ResourceType, p *global.DataPolicyType) (DataSourceSchema, error) {
+	esquema := DataSourceSchema{
+		Name:        ds.Name,
+		Description: ds.Description,
+		FreeSQL:     !ds.DisableFreeSQL,
+		Templates:   esquemaPlantillas(ds),
+	}
+
+	db, err := abrirSandbox()
+	if err != nil {
+		return esquema, err
+	}
+	defer db.Close()
+
+	origen, vistas, _, err := prepararOrigen(db, ds, p)
+	if err != nil {
+		return esquema, err
+	}
+	if len(vistas) == 0 {
+		origen = literal(origen)
+	}
+
+	rows, err := db.Query("DESCRIBE SELECT * FROM " + origen)
+	if err != nil {
+		return esquema, err
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	valores := make([]sql.NullString, len(cols))
+	punteros := make([]interface{}, len(cols))
+	for i := range valores {
+		punteros[i] = &valores[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(punteros...); err != nil {
+			return esquema, err
+		}
+		col := ColumnSchema{Name: valores[0].String, Type: valores[1].String}
+		col.Description = ds.Columns[col.Name]
+		if p != nil {
+			col.Masked = mascara(p, col.Name)
+		}
+		esquema.Columns = append(esquema.Columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return esquema, err
+	}
+
+	// La estimación sale de los metadatos de parquet. Con filtros de fila se
+	// omite para no revelar cuántas filas quedan fuera.
+	archivo := archivoFuente(ds)
+	if strings.HasSuffix(strings.ToLower(archivo), ".parquet") && (p == nil || len(p.Where) == 0) {
+		var filas int64
+		err := db.QueryRow("SELECT sum(num_rows)::BIGINT FROM parquet_file_metadata(" + literal(archivo) + ")").Scan(&filas)
+		if err == nil {
+			esquema.RowsEstimate = &filas
+		}
+	}
+	return esquema, nil
+}
+
+func mascara(p *global.DataPolicyType, columna string) string {
+	for _, c := range p.Hash {
+		if c == columna {
+			return "hash"
+		}
+	}
+	for _, c := range p.Redact {
+		if c == columna {
+			return "redact"
+		}
+	}
+	return ""
+}
+
+// Esquema pide al proveedor la descripción de un DATA_SOURCE, o de todos los
+// visibles si service está vacío.
+func (n *Network) Esquema(targetID peer.ID, service string) ([]DataSourceSchema, error) {
+	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolQuerySchema)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo stream: %w", err)
+	}
+	defer s.Close()
+
+	data, _ := proto.Marshal(&global.Envelop{Id: uuid.New().String(), Service: service})
+	if _, err := writeDelimited(s, data); err != nil {
+		return nil, fmt.Errorf("error enviando solicitud: %w", err)
+	}
+	res, err := leerEnvelop(bufio.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	if res.Extra == frameError {
+		qe := &QueryError{}
+		json.Unmarshal(res.Payload, qe)
+		return nil, qe
+	}
+	var esquemas []DataSourceSchema
+	if err := json.Unmarshal(res.Payload, &esquemas); err != nil {
+		return nil, fmt.Errorf("error decodificando esquema: %w", err)
+	}
+	return esquemas, nil
+}
@@ -0,0 +1,367 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// columnaCambio marca cada fila de un cambio como insert o delete. Una
+	// fila modificada llega como delete de la versión vieja e insert de la
+	// nueva.
+	columnaCambio = "_change"
+
+	intervaloVigilancia         = 2 * time.Second
+	intervaloSuscripcionDefecto = time.Minute
+)
+
+// SubscriptionType es una consulta que el proveedor vuelve a evaluar cuando
+// cambian los archivos de la fuente y, con IntervalSeconds, también de forma
+// periódica. Las fuentes sin archivos locales solo se evalúan por intervalo.
+type SubscriptionType struct {
+	QueryType
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+}
+
+// suscripcion guarda el último resultado en la tabla temporal anterior de su
+// propio sandbox; cada evaluación lo compara con el nuevo usando EXCEPT ALL.
+type suscripcion struct {
+	conn    *sql.Conn
+	ds      global.ResourceType
+	query   string
+	args    []interface{}
+	formato *QueryType
+	version int64
+}
+
+// handleQuerySubscribe atiende una suscripción mientras el consumidor
+// mantenga el stream abierto. La primera entrega es el resultado completo;
+// las siguientes, solo las filas que cambiaron.
+func (n *Network) handleQuerySubscribe(s network.Stream) {
+	defer s.Close()
+	remotePeer := s.Conn().RemotePeer()
+
+	data, err := readDelimited(s)
+	if err != nil {
+		if err != io.EOF {
+			log.Printf("Error leyendo stream: %v", err)
+		}
+		return
+	}
+	msg := &global.Envelop{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		log.Printf("Error unmarshal protobuf: %v", err)
+		return
+	}
+
+	var sub SubscriptionType
+	dec := json.NewDecoder(bytes.NewReader(msg.Payload))
+	dec.UseNumber()
+	if err := dec.Decode(&sub); err != nil {
+		log.Error("Error al convertir el payload a SubscriptionType: ", err)
+		return
+	}
+	e := nuevoEmisor(s, msg.Id, sub.Format)
+	if !formatoReanudable(sub.Format) {
+		e.fallar(QueryError{Code: errUnsupportedFormat, Message: "formato no soportado en suscripciones: " + sub.Format})
+		return
+	}
+	if sub.BlockSize <= 0 {
+		sub.BlockSize = bloqueDefecto
+	}
+
+	var ds *global.ResourceType
+	for i := range n.Resources.DATASOURCE {
+		if n.Resources.DATASOURCE[i].Name == msg.Service {
+			ds = &n.Resources.DATASOURCE[i]
+			break
+		}
+	}
+	if ds == nil || !RBAC.Allowed(remotePeer, n.Name, global.ProtocolQuery, ds.Name) {
+		e.fallar(QueryError{Code: errDataSourceNotFound, Message: "fuente de datos no encontrada: " + msg.Service})
+		return
+	}
+	if ok, espera := n.permitirCuota(remotePeer, *ds); !ok {
+		log.Warn("Cuota excedida por ", RBAC.Entity(remotePeer), " en ", ds.Name)
+		e.fallar(QueryError{Code: errQuotaExceeded, Message: "cuota excedida", RetryAfter: segundosReintento(espera)})
+		return
+	}
+	e.s = n.medirCuota(e.s, remotePeer, *ds)
+
+	db, err := abrirSandboxLimitado(ds.Limits)
+	if err != nil {
+		log.Error("Error al abrir la base de datos: ", err)
+		e.fallar(QueryError{Code: errQueryFailed, Message: "no se pudo preparar la consulta"})
+		return
+	}
+	defer db.Close()
+	// El tiempo máximo se aplica a cada evaluación, no a la suscripción.
+	ctx, cancel := contextoConsulta(s, nil)
+	defer cancel()
+
+	origen, vistas, archivos, err := prepararOrigen(db, *ds, politicaPara(*ds, RBAC.Entity(remotePeer)))
+	if err != nil {
+		log.Error(err)
+		e.fallar(QueryError{Code: errQueryFailed, Message: "no se pudo preparar la fuente de datos"})
+		return
+	}
+	consulta, args, qe := consultaSolicitada(*ds, &sub.QueryType)
+	if qe != nil {
+		e.fallar(*qe)
+		return
+	}
+	query := strings.ReplaceAll(consulta, "{{ORIGIN}}", origen)
+	if err := validarConsulta(db, query, vistas, archivos); err != nil {
+		log.Warn("Suscripción rechazada de ", RBAC.Entity(remotePeer), " en ", ds.Name, ": ", err)
+		e.fallar(QueryError{Code: errQueryRejected, Message: err.Error()})
+		return
+	}
+
+	columnas, err := columnasConsulta(ctx, db, query, args)
+	if err != nil {
+		e.fallar(errorEjecucion(ctx, err))
+		return
+	}
+	columnas = append([]ColumnSchema{{Name: columnaCambio, Type: "VARCHAR"}}, columnas...)
+	if err := e.enviarCabecera(columnas); err != nil {
+		log.Error("Error enviando cabecera: ", err)
+		return
+	}
+
+	var vigilancia, programa <-chan time.Time
+	version := versionFuente(*ds)
+	if version != "" {
+		t := time.NewTicker(intervaloVigilancia)
+		defer t.Stop()
+		vigilancia = t.C
+	}
+	intervalo := time.Duration(sub.IntervalSeconds) * time.Second
+	if intervalo <= 0 && version == "" {
+		intervalo = intervaloSuscripcionDefecto
+	}
+	if intervalo > 0 {
+		t := time.NewTicker(intervalo)
+		defer t.Stop()
+		programa = t.C
+	}
+
+	// Las tablas de la diferencia son temporales y viven en una conexión: se
+	// usa la misma durante toda la suscripción.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		e.fallar(errorEjecucion(ctx, err))
+		return
+	}
+	defer conn.Close()
+
+	log.Infof("Suscripción %s de %s a %s", msg.Id, RBAC.Entity(remotePeer), ds.Name)
+	sus := &suscripcion{conn: conn, ds: *ds, query: query, args: args, formato: &sub.QueryType}
+	for {
+		if err := sus.evaluar(ctx, e); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Errorf("Error evaluando la suscripción %s: %v", msg.Id, err)
+			e.fallar(errorEjecucion(ctx, err))
+			return
+		}
+
+	espera:
+		for {
+			select {
+			case <-ctx.Done():
+				break espera
+			case <-programa:
+				break espera
+			case <-vigilancia:
+				// Sin versión el archivo se está reemplazando; se espera al
+				// siguiente intento.
+				if v := versionFuente(*ds); v != "" && v != version {
+					version = v
+					break espera
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	log.Infof("Suscripción %s terminada después de %d cambios", msg.Id, sus.version)
+}
+
+// evaluar ejecuta la consulta y envía la diferencia con el resultado
+// anterior, si la hay. La primera evaluación envía todas las filas.
+func (sus *suscripcion) evaluar(ctx context.Context, e *emisorQuery) error {
+	ctx, cancel := plazoConsulta(ctx, sus.ds.Limits)
+	defer cancel()
+
+	err := sus.diferencia(ctx, e)
+	if err != nil {
+		qe := errorEjecucion(ctx, err)
+		return &qe
+	}
+	return nil
+}
+
+func (sus *suscripcion) diferencia(ctx context.Context, e *emisorQuery) error {
+	if _, err := sus.conn.ExecContext(ctx, "CREATE OR REPLACE TEMPORARY TABLE actual AS SELECT * FROM ("+sus.query+"\n)", sus.args...); err != nil {
+		return err
+	}
+	if max := limiteFilas(sus.ds.Limits); max > 0 {
+		var filas int64
+		if err := sus.conn.QueryRowContext(ctx, "SELECT count(*) FROM actual").Scan(&filas); err != nil {
+			return err
+		}
+		if filas > max {
+			return excesoFilas(max)
+		}
+	}
+
+	cambios := "SELECT 'insert' AS " + columnaCambio + ", * FROM actual"
+	if sus.version > 0 {
+		cambios = "SELECT 'insert' AS " + columnaCambio + ", * FROM (SELECT * FROM actual EXCEPT ALL SELECT * FROM anterior)" +
+			" UNION ALL SELECT 'delete', * FROM (SELECT * FROM anterior EXCEPT ALL SELECT * FROM actual)"
+	}
+	if _, err := sus.conn.ExecContext(ctx, "CREATE OR REPLACE TEMPORARY TABLE cambios AS "+cambios); err != nil {
+		return err
+	}
+	var c QueryChange
+	err := sus.conn.QueryRowContext(ctx, "SELECT count(*) FILTER (WHERE "+columnaCambio+" = 'insert'), count(*) FILTER (WHERE "+columnaCambio+" = 'delete') FROM cambios").
+		Scan(&c.Inserted, &c.Deleted)
+	if err != nil {
+		return err
+	}
+	if _, err := sus.conn.ExecContext(ctx, "DROP TABLE IF EXISTS anterior; ALTER TABLE actual RENAME TO anterior"); err != nil {
+		return err
+	}
+	if sus.version > 0 && c.Inserted+c.Deleted == 0 {
+		return nil
+	}
+
+	if _, err := enviarResultado(ctx, sus.conn, escritorConLimite(e, sus.ds.Limits), "SELECT * FROM cambios", nil, sus.formato, 0, nil); err != nil {
+		return err
+	}
+	sus.version++
+	c.Version = sus.version
+	return e.cambio(c)
+}
+
+// Suscribir registra una suscripción en el proveedor y llama a recibir con
+// cada cambio y sus filas, unidas en un solo documento del formato pedido.
+// Termina al cancelar ctx, si el proveedor cierra la suscripción o si
+// recibir devuelve un error.
+func (n *Network) Suscribir(ctx context.Context, targetID peer.ID, sub SubscriptionType, service string, recibir func(QueryChange, []byte) error) error {
+	s, err := n.Host.NewStream(ctx, targetID, global.ProtocolQuerySubscribe)
+	if err != nil {
+		return fmt.Errorf("error abriendo stream: %w", err)
+	}
+	defer s.Close()
+	detener := context.AfterFunc(ctx, func() { s.Reset() })
+	defer detener()
+
+	payload, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("error al codificar JSON: %w", err)
+	}
+	msg := &global.Envelop{Id: uuid.New().String(), Service: service, Payload: payload}
+	data, _ := proto.Marshal(msg)
+	if _, err := writeDelimited(s, data); err != nil {
+		return fmt.Errorf("error enviando suscripción: %w", err)
+	}
+
+	var buf bytes.Buffer
+	est := &lecturaQuery{}
+	var esperado uint64
+	br := bufio.NewReader(s)
+	for {
+		frame, err := leerEnvelop(br)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return fmt.Errorf("el proveedor cerró la suscripción %s", msg.Id)
+			}
+			return fmt.Errorf("error leyendo trama: %w", err)
+		}
+		if frame.Id != msg.Id || frame.Seq != esperado {
+			return fmt.Errorf("trama inesperada: id %s, seq %d (esperado %d)", frame.Id, frame.Seq, esperado)
+		}
+		esperado++
+
+		switch frame.Extra {
+		case frameHeader:
+			log.Debugf("Suscripción %s a %s aceptada", msg.Id, service)
+		case frameData:
+			if err := est.escribir(&buf, sub.Format, frame.Payload); err != nil {
+				return err
+			}
+		case frameChange:
+			var c QueryChange
+			if err := json.Unmarshal(frame.Payload, &c); err != nil {
+				return fmt.Errorf("error decodificando cambio: %w", err)
+			}
+			if err := est.terminar(&buf, sub.Format); err != nil {
+				return err
+			}
+			if err := recibir(c, buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+			est = &lecturaQuery{}
+		case frameTrailer:
+			cierre := &QueryTrailer{}
+			if err := json.Unmarshal(frame.Payload, cierre); err != nil {
+				return fmt.Errorf("error decodificando cierre: %w", err)
+			}
+			if cierre.Status != estadoOK {
+				qe := cierre.Error
+				if qe == nil {
+					qe = &QueryError{Code: errQueryFailed, Message: "error desconocido"}
+				}
+				return qe
+			}
+			return nil
+		default:
+			return fmt.Errorf("trama desconocida: %s", frame.Extra)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func (n *Network) handleTCPTunnelStream(s network.Stream) {
+	defer s.Close()
+	remotePeer := s.Conn().RemotePeer()
+
+	if !RBAC.HasPermition2Protocol(remotePeer, n.Name, global.ProtocolTCPTunnel) {
+		log.Debug("Denegado, sin permiso al protocolo: ", remotePeer.String(), n.Name, global.ProtocolTCPTunnel)
+		s.Reset()
+		return
+	}
+
+	br := bufio.NewReader(s)
+	data, err := readDelimited(br)
+	if err != nil {
+		log.Printf("Error leyendo stream: %v", err)
+		return
+	}
+	msg := &global.Envelop{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		log.Printf("Error unmarshal protobuf: %v", err)
+		return
+	}
+
+	if !RBAC.Allowed(remotePeer, n.Name, global.ProtocolTCPTunnel, msg.Service) {
+		log.Debug("Denegado, sin permiso al servicio: ", remotePeer.String(), n.Name, global.ProtocolTCPTunnel, msg.Service)
+		s.Reset()
+		return
+	}
+
+	var recurso *global.ResourceType
+	for i, resource := range n.Resources.TCP {
+		if resource.Name == msg.Service {
+			recurso = &n.Resources.TCP[i]
+			break
+		}
+	}
+	if recurso == nil || recurso.Address == "" {
+		responderTunel(s, msg.Id, "ERR: servicio no encontrado")
+		return
+	}
+	if ok, espera := n.permitirCuota(remotePeer, *recurso); !ok {
+		responderTunel(s, msg.Id, fmt.Sprintf("ERR: cuota excedida; retry-after=%d", segundosReintento(espera)))
+		return
+	}
+	s = n.medirCuota(s, remotePeer, *recurso)
+	destino := recurso.Address
+
+	conn, err := net.Dial("tcp", destino)
+	if err != nil {
+		log.Error("Error conectando al servicio TCP ", destino, ": ", err)
+		responderTunel(s, msg.Id, "ERR: "+err.Error())
+		return
+	}
+	defer conn.Close()
+
+	if err := responderTunel(s, msg.Id, "OK"); err != nil {
+		log.Printf("Error respondiendo: %v", err)
+		return
+	}
+
+	fmt.Printf("🔌 Túnel TCP abierto %s -> %s (%s)\n", remotePeer.String()[:6], destino, msg.Service)
+	enviados, recibidos := unirConexiones(conn, s, br, 0)
+	fmt.Printf("🔌 Túnel TCP cerrado %s: %d bytes enviados, %d recibidos\n", msg.Service, enviados, recibidos)
+}
+
+// TCPTunnels abre un listener local por cada recurso TCP remoto y lleva cada
+// conexión aceptada a un proveedor del servicio a través de la red.
+func (n *Network) TCPTunnels() {
+	for _, resource := range n.RemoteResources.TCP {
+		if resource.Address == "" {
+			log.Warn("Recurso TCP remoto sin dirección local: ", resource.Name)
+			continue
+		}
+		listener, err := net.Listen("tcp", resource.Address)
+		if err != nil {
+			log.Error("Error escuchando en ", resource.Address, ": ", err)
+			continue
+		}
+		fmt.Printf("🔌 Túnel TCP '%s' escuchando en %s\n", resource.Name, resource.Address)
+		go n.aceptarTunel(listener, resource.Name)
+	}
+}
+
+func (n *Network) aceptarTunel(listener net.Listener, service string) {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Error("Error aceptando conexión: ", err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+
+			targetID := n.BuscarServicio(context.Background(), service)
+			if targetID == "" {
+				log.Error("Servicio no encontrado")
+				return
+			}
+			if err := n.AbrirTunel(targetID, service, conn); err != nil {
+				log.Error("Error en el túnel TCP: ", err)
+			}
+		}()
+	}
+}
+
+// AbrirTunel conecta conn con el servicio TCP publicado por targetID y copia
+// los bytes en ambos sentidos hasta que alguno de los extremos cierre.
+func (n *Network) AbrirTunel(targetID peer.ID, service string, conn net.Conn) error {
+	s, err := n.Host.NewStream(context.Background(), targetID, global.ProtocolTCPTunnel)
+	if err != nil {
+		return fmt.Errorf("error abriendo stream: %w", err)
+	}
+	defer s.Close()
+
+	msg := &global.Envelop{
+		Id:      uuid.New().String(),
+		Service: service,
+	}
+	data, _ := proto.Marshal(msg)
+	if _, err := writeDelimited(s, data); err != nil {
+		return fmt.Errorf("error enviando solicitud: %w", err)
+	}
+
+	br := bufio.NewReader(s)
+	resData, err := readDelimited(br)
+	if err != nil {
+		return fmt.Errorf("error leyendo respuesta: %w", err)
+	}
+	res := &global.Envelop{}
+	if err := proto.Unmarshal(resData, res); err != nil {
+		return fmt.Errorf("error unmarshal protobuf: %w", err)
+	}
+	if status := string(res.Payload); !strings.HasPrefix(status, "OK") {
+		return fmt.Errorf("error del servidor: %s", status)
+	}
+
+	unirConexiones(conn, s, br, 0)
+	return nil
+}
+
+func responderTunel(s network.Stream, id string, status string) error {
+	resp := &global.Envelop{
+		Id:      id,
+		Payload: []byte(status),
+	}
+	out, _ := proto.Marshal(resp)
+	_, err := writeDelimited(s, out)
+	return err
+}
+
+// unirConexiones copia conn -> s y sr -> conn. sr es el lector del stream, que
+// puede traer bytes ya almacenados en el buffer del saludo inicial. Si
+// inactividad es mayor que cero, ambos extremos se cierran cuando pasa ese
+// tiempo sin tráfico en ninguna dirección.
+func unirConexiones(conn net.Conn, s network.Stream, sr io.Reader, inactividad time.Duration) (int64, int64) {
+	var enviados, recibidos int64
+	var actividad atomic.Int64
+	actividad.Store(time.Now().UnixNano())
+
+	fin := make(chan struct{})
+	if inactividad > 0 {
+		go func() {
+			ticker := time.NewTicker(inactividad / 4)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-fin:
+					return
+				case <-ticker.C:
+					if time.Since(time.Unix(0, actividad.Load())) > inactividad {
+						log.Debug("Conexión inactiva, cerrando túnel")
+						conn.Close()
+						s.Reset()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		recibidos, _ = io.Copy(s, &lectorActivo{r: conn, actividad: &actividad})
+		s.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		enviados, _ = io.Copy(conn, &lectorActivo{r: sr, actividad: &actividad})
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	wg.Wait()
+	close(fin)
+	return enviados, recibidos
+}
+
+// lectorActivo registra la hora de cada lectura con datos.
+type lectorActivo struct {
+	r         io.Reader
+	actividad *atomic.Int64
+}
+
+func (l *lectorActivo) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.actividad.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,297 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+const cursorTTLDefecto = 10 * time.Minute
+
+const errCursorLimit = "CURSOR_LIMIT_EXCEEDED"
+
+// Cada peer puede tener a la vez hasta maxCursoresPorPeer cursores abiertos.
+// El archivo de un cursor no pasa de MaxBytes del DATA_SOURCE o, sin ese
+// límite, de maxBytesCursorDefecto.
+const (
+	maxCursoresPorPeer    = 8
+	maxBytesCursorDefecto = 1 << 30
+)
+
+// dirPrivado guarda los archivos que genera el nodo (cursores e
+// instantáneas). Se crea con permisos 0700: un nombre en el temporal
+// compartido permitiría a otro usuario adelantarse con un enlace o leerlos.
+var dirPrivado = sync.OnceValues(func() (string, error) {
+	return os.MkdirTemp("", "veredarii-")
+})
+
+// cursorConsulta es un resultado guardado en un archivo parquet para leerlo
+// por páginas. Solo lo puede leer el peer que lo abrió, y se borra si pasa
+// el TTL sin usarse.
+type cursorConsulta struct {
+	id          string
+	servicio    string
+	propietario peer.ID
+	archivo     string
+	filas       int64
+	columnas    []ColumnSchema
+	ttl         time.Duration
+	timer       *time.Timer
+}
+
+// abrirCursor ejecuta la consulta y guarda el resultado completo.
+func (n *Network) abrirCursor(ctx context.Context, db *sql.DB, ds global.ResourceType, propietario peer.ID, query string, args []interface{}, columnas []ColumnSchema) (*cursorConsulta, error) {
+	if err := n.reservarCursor(propietario); err != nil {
+		return nil, err
+	}
+	c, err := exportarCursor(ctx, db, ds, propietario, query, args, columnas)
+	if err != nil {
+		n.liberarCursor(propietario)
+		return nil, err
+	}
+	n.mutexCursores.Lock()
+	n.cursores[c.id] = c
+	n.mutexCursores.Unlock()
+	c.timer = time.AfterFunc(c.ttl, func() { n.cerrarCursor(c.id) })
+	log.Debugf("Cursor %s abierto: %d filas en %s", c.id, c.filas, c.archivo)
+	return c, nil
+}
+
+// exportarCursor escribe el resultado en el directorio privado y lo corta si
+// pasa del máximo de filas o de bytes.
+func exportarCursor(ctx context.Context, db *sql.DB, ds global.ResourceType, propietario peer.ID, query string, args []interface{}, columnas []ColumnSchema) (*cursorConsulta, error) {
+	dir, err := dirPrivado()
+	if err != nil {
+		return nil, fmt.Errorf("error creando directorio de cursores: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "cursor-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("error creando archivo temporal: %w", err)
+	}
+	f.Close()
+
+	maxFilas := limiteFilas(ds.Limits)
+	if maxFilas > 0 {
+		query = fmt.Sprintf("SELECT * FROM (%s\n) LIMIT %d", query, maxFilas+1)
+	}
+	maxBytes := limiteBytesCursor(ds.Limits)
+	ctxExport, cancelar := context.WithCancel(ctx)
+	vigilancia := vigilarTamano(ctxExport, cancelar, f.Name(), maxBytes)
+	filas, err := exportToParquet(ctxExport, db, query, f.Name(), args...)
+	cancelar()
+	switch {
+	case <-vigilancia:
+		err = &QueryError{Code: errByteLimit, Message: fmt.Sprintf("el resultado supera el máximo de %d bytes", maxBytes)}
+	case err == nil && maxFilas > 0 && filas > maxFilas:
+		err = excesoFilas(maxFilas)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	c := &cursorConsulta{
+		id:          uuid.New().String(),
+		servicio:    ds.Name,
+		propietario: propietario,
+		archivo:     f.Name(),
+		filas:       filas,
+		columnas:    columnas,
+		ttl:         cursorTTLDefecto,
+	}
+	if ds.CursorTTLSeconds > 0 {
+		c.ttl = time.Duration(ds.CursorTTLSeconds) * time.Second
+	}
+	return c, nil
+}
+
+// reservarCursor cuenta el cursor del peer antes de exportarlo, para que
+// varias aperturas simultáneas no pasen juntas el máximo.
+func (n *Network) reservarCursor(propietario peer.ID) error {
+	n.mutexCursores.Lock()
+	defer n.mutexCursores.Unlock()
+	if n.cursoresPorPeer[propietario] >= maxCursoresPorPeer {
+		return &QueryError{Code: errCursorLimit, Message: fmt.Sprintf("se alcanzó el máximo de %d cursores abiertos", maxCursoresPorPeer)}
+	}
+	n.cursoresPorPeer[propietario]++
+	return nil
+}
+
+func (n *Network) liberarCursor(propietario peer.ID) {
+	n.mutexCursores.Lock()
+	defer n.mutexCursores.Unlock()
+	if n.cursoresPorPeer[propietario]--; n.cursoresPorPeer[propietario] <= 0 {
+		delete(n.cursoresPorPeer, propietario)
+	}
+}
+
+func limiteBytesCursor(l *global.QueryLimitsType) int64 {
+	if l == nil || l.MaxBytes <= 0 {
+		return maxBytesCursorDefecto
+	}
+	return l.MaxBytes
+}
+
+// vigilarTamano revisa el archivo mientras DuckDB lo escribe y cancela la
+// exportación si pasa de max bytes. Al terminar ctx el canal entrega si el
+// archivo se pasó.
+func vigilarTamano(ctx context.Context, cancelar context.CancelFunc, ruta string, max int64) <-chan bool {
+	excedido := make(chan bool, 1)
+	go func() {
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				info, err := os.Stat(ruta)
+				excedido <- err == nil && info.Size() > max
+				return
+			case <-t.C:
+				if info, err := os.Stat(ruta); err == nil && info.Size() > max {
+					cancelar()
+					excedido <- true
+					return
+				}
+			}
+		}
+	}()
+	return excedido
+}
+
+// buscarCursor devuelve el cursor si existe y es del peer, y renueva su TTL.
+func (n *Network) buscarCursor(id, servicio string, propietario peer.ID) *cursorConsulta {
+	n.mutexCursores.Lock()
+	defer n.mutexCursores.Unlock()
+	c, ok := n.cursores[id]
+	if !ok || c.servicio != servicio || c.propietario != propietario {
+		return nil
+	}
+	c.timer.Reset(c.ttl)
+	return c
+}
+
+func (n *Network) cerrarCursor(id string) {
+	n.mutexCursores.Lock()
+	c, ok := n.cursores[id]
+	delete(n.cursores, id)
+	n.mutexCursores.Unlock()
+	if ok {
+		n.liberarCursor(c.propietario)
+		c.timer.Stop()
+		os.Remove(c.archivo)
+		log.Debug("Cursor cerrado: ", id)
+	}
+}
+
+// paginaCursor atiende una consulta en modo cursor: la primera vez abre el
+// cursor, después lee de uno abierto por CursorID o PageToken.
+func (n *Network) paginaCursor(ctx context.Context, db *sql.DB, e *emisorQuery, ds global.ResourceType, propietario peer.ID, queryType *QueryType) {
+	id, offset := queryType.CursorID, queryType.Offset
+	if queryType.PageToken != "" {
+		var err error
+		if id, offset, err = leerTokenPagina(queryType.PageToken); err != nil {
+			e.fallar(QueryError{Code: errInvalidParams, Message: err.Error()})
+			return
+		}
+	}
+	c := n.buscarCursor(id, ds.Name, propietario)
+	if c == nil {
+		e.fallar(QueryError{Code: errDataSourceNotFound, Message: "cursor no encontrado o vencido: " + id})
+		return
+	}
+	n.enviarPagina(ctx, db, e, ds, c, offset, queryType)
+}
+
+// enviarPagina envía las filas del cursor desde offset. En los formatos de
+// texto cada bloque se confirma con una trama de avance, para que el
+// consumidor pueda retomar desde ahí si el stream se corta.
+func (n *Network) enviarPagina(ctx context.Context, db *sql.DB, e *emisorQuery, ds global.ResourceType, c *cursorConsulta, offset int64, queryType *QueryType) {
+	if offset < 0 || offset > c.filas {
+		e.fallar(QueryError{Code: errInvalidParams, Message: fmt.Sprintf("offset fuera del resultado: %d de %d filas", offset, c.filas)})
+		return
+	}
+	hasta := c.filas
+	if queryType.Limit > 0 {
+		hasta = min(offset+queryType.Limit, c.filas)
+	}
+
+	e.cursor, e.desde, e.total = c.id, offset, c.filas
+	if err := e.enviarCabecera(c.columnas); err != nil {
+		log.Error("Error enviando cabecera: ", err)
+		return
+	}
+
+	query := fmt.Sprintf("SELECT * FROM read_parquet(%s) LIMIT %d OFFSET %d", literal(c.archivo), hasta-offset, offset)
+	pagina := *queryType
+	pagina.sinCabecera = offset > 0
+	filas, err := enviarResultado(ctx, db, escritorConLimite(e, ds.Limits), query, nil, &pagina, 0, func(enviadas int64) error {
+		return e.progreso(offset + enviadas)
+	})
+	if err != nil {
+		log.Errorf("Error leyendo cursor %s: %v", c.id, err)
+		e.fallar(errorEjecucion(ctx, err))
+		return
+	}
+
+	cierre := QueryTrailer{Status: estadoOK, Rows: filas, CursorID: c.id}
+	if offset+filas < c.filas {
+		cierre.NextOffset = offset + filas
+		cierre.NextToken = tokenPagina(c.id, cierre.NextOffset)
+	}
+	e.cerrar(cierre)
+}
+
+func tokenPagina(id string, offset int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id + ":" + strconv.FormatInt(offset, 10)))
+}
+
+func leerTokenPagina(token string) (string, int64, error) {
+	datos, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", 0, fmt.Errorf("token de página inválido")
+	}
+	id, offset, ok := strings.Cut(string(datos), ":")
+	if !ok {
+		return "", 0, fmt.Errorf("token de página inválido")
+	}
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("token de página inválido")
+	}
+	return id, n, nil
+}
@@ -0,0 +1,69 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	global "Veredarii/global"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestReservarCursor(t *testing.T) {
+	n := NewNetwork("red", "0", "", nil, nil, nil, nil, global.ResourcesType{}, global.ResourcesType{})
+	a, b := peer.ID("a"), peer.ID("b")
+	for i := 0; i < maxCursoresPorPeer; i++ {
+		if err := n.reservarCursor(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var qe *QueryError
+	if err := n.reservarCursor(a); !errors.As(err, &qe) || qe.Code != errCursorLimit {
+		t.Fatalf("se esperaba %s, llegó %v", errCursorLimit, err)
+	}
+	if err := n.reservarCursor(b); err != nil {
+		t.Errorf("el máximo es por peer: %v", err)
+	}
+	n.liberarCursor(a)
+	if err := n.reservarCursor(a); err != nil {
+		t.Errorf("al cerrar un cursor se libera su lugar: %v", err)
+	}
+}
+
+// La exportación se corta al pasar MaxBytes y no deja el archivo.
+func TestExportarCursorLimiteBytes(t *testing.T) {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ds := global.ResourceType{Name: "ds", Limits: &global.QueryLimitsType{MaxBytes: 4096}}
+	query := "SELECT range AS n, md5(range::VARCHAR) AS h FROM range(200000)"
+
+	_, err = exportarCursor(context.Background(), db, ds, "", query, nil, nil)
+	var qe *QueryError
+	if !errors.As(err, &qe) || qe.Code != errByteLimit {
+		t.Fatalf("se esperaba %s, llegó %v", errByteLimit, err)
+	}
+	dir, err := dirPrivado()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quedan, _ := filepath.Glob(filepath.Join(dir, "cursor-*")); len(quedan) > 0 {
+		t.Errorf("quedaron archivos de cursor: %v", quedan)
+	}
+
+	ds.Limits = nil
+	c, err := exportarCursor(context.Background(), db, ds, "", query, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(c.archivo)
+	if filepath.Dir(c.archivo) != dir || c.filas != 200000 {
+		t.Errorf("cursor en %s con %d filas", c.archivo, c.filas)
+	}
+}
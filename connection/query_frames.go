@@ -0,0 +1,173 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// Tramas del resultado de una consulta. Todas llevan el Id de la consulta y
+// un Seq correlativo: primero una cabecera, luego los datos y al final un
+// cierre con el resumen o el error. En modo cursor, cada bloque de datos va
+// seguido de una trama de avance con la posición alcanzada. En una
+// suscripción, los datos de cada cambio terminan con una trama de cambio.
+const (
+	frameHeader   = "header"
+	frameData     = "data"
+	frameProgress = "progress"
+	frameChange   = "change"
+	frameTrailer  = "trailer"
+
+	estadoOK    = "ok"
+	estadoError = "error"
+)
+
+type QueryHeader struct {
+	QueryID   string         `json:"query_id"`
+	Format    string         `json:"format"`
+	Columns   []ColumnSchema `json:"columns,omitempty"`
+	CursorID  string         `json:"cursor_id,omitempty"`
+	Offset    int64          `json:"offset,omitempty"`
+	TotalRows int64          `json:"total_rows,omitempty"`
+}
+
+type QueryProgress struct {
+	Offset int64 `json:"offset"`
+}
+
+// QueryChange resume un cambio de una suscripción. Las filas van en las
+// tramas de datos anteriores, con la columna _change en insert o delete.
+type QueryChange struct {
+	Version  int64 `json:"version"`
+	Inserted int64 `json:"inserted"`
+	Deleted  int64 `json:"deleted"`
+}
+
+type QueryTrailer struct {
+	QueryID    string      `json:"query_id"`
+	Status     string      `json:"status"`
+	Rows       int64       `json:"rows"`
+	DurationMs int64       `json:"duration_ms"`
+	Error      *QueryError `json:"error,omitempty"`
+	// Cached indica que el resultado salió de la caché del proveedor.
+	Cached bool `json:"cached,omitempty"`
+	// En modo cursor, NextOffset y NextToken apuntan a la página siguiente
+	// mientras queden filas.
+	CursorID   string `json:"cursor_id,omitempty"`
+	NextOffset int64  `json:"next_offset,omitempty"`
+	NextToken  string `json:"next_token,omitempty"`
+}
+
+// emisorQuery escribe las tramas de una consulta en el stream. Cada Write es
+// una trama de datos.
+type emisorQuery struct {
+	s        network.Stream
+	id       string
+	formato  string
+	seq      uint64
+	inicio   time.Time
+	cabecera bool
+	// Datos del cursor que se informan en la cabecera.
+	cursor string
+	desde  int64
+	total  int64
+}
+
+func nuevoEmisor(s network.Stream, id string, formato string) *emisorQuery {
+	return &emisorQuery{s: s, id: id, formato: formato, inicio: time.Now()}
+}
+
+func (e *emisorQuery) trama(tipo string, payload []byte) error {
+	out, _ := proto.Marshal(&global.Envelop{Id: e.id, Seq: e.seq, Extra: tipo, Payload: payload})
+	e.seq++
+	_, err := writeDelimited(e.s, out)
+	return err
+}
+
+func (e *emisorQuery) enviarCabecera(columnas []ColumnSchema) error {
+	e.cabecera = true
+	payload, _ := json.Marshal(QueryHeader{QueryID: e.id, Format: e.formato, Columns: columnas, CursorID: e.cursor, Offset: e.desde, TotalRows: e.total})
+	return e.trama(frameHeader, payload)
+}
+
+func (e *emisorQuery) progreso(offset int64) error {
+	payload, _ := json.Marshal(QueryProgress{Offset: offset})
+	return e.trama(frameProgress, payload)
+}
+
+func (e *emisorQuery) cambio(c QueryChange) error {
+	payload, _ := json.Marshal(c)
+	return e.trama(frameChange, payload)
+}
+
+func (e *emisorQuery) Write(p []byte) (int, error) {
+	if err := e.trama(frameData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (e *emisorQuery) terminar(filas int64) {
+	e.cerrar(QueryTrailer{Status: estadoOK, Rows: filas})
+}
+
+func (e *emisorQuery) fallar(qe QueryError) {
+	e.cerrar(QueryTrailer{Status: estadoError, Error: &qe})
+}
+
+func (e *emisorQuery) cerrar(t QueryTrailer) {
+	if !e.cabecera {
+		e.enviarCabecera(nil)
+	}
+	t.QueryID = e.id
+	t.DurationMs = time.Since(e.inicio).Milliseconds()
+	payload, _ := json.Marshal(t)
+	e.trama(frameTrailer, payload)
+}
+
+// columnasConsulta obtiene el esquema del resultado sin leer filas.
+func columnasConsulta(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]ColumnSchema, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM ("+query+"\n) LIMIT 0", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tipos, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columnas := make([]ColumnSchema, len(tipos))
+	for i, t := range tipos {
+		columnas[i] = ColumnSchema{Name: t.Name(), Type: t.DatabaseTypeName()}
+	}
+	return columnas, nil
+}
@@ -0,0 +1,147 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// Errores de una consulta que excede los límites del DATA_SOURCE o que el
+// consumidor abandona.
+const (
+	errTimeout       = "TIMEOUT"
+	errRowLimit      = "ROW_LIMIT_EXCEEDED"
+	errByteLimit     = "BYTE_LIMIT_EXCEEDED"
+	errMemoryLimit   = "MEMORY_LIMIT_EXCEEDED"
+	errQueryCanceled = "CANCELED"
+)
+
+// dsnLimites agrega al DSN del sandbox la memoria y los hilos permitidos.
+// Como la configuración queda bloqueada, la consulta no puede subirlos.
+func dsnLimites(l *global.QueryLimitsType) string {
+	dsn := dsnSandbox
+	if l == nil {
+		return dsn
+	}
+	if l.MemoryLimit != "" {
+		dsn += "&memory_limit=" + url.QueryEscape(l.MemoryLimit)
+	}
+	if l.Threads > 0 {
+		dsn += "&threads=" + strconv.Itoa(l.Threads)
+	}
+	return dsn
+}
+
+func abrirSandboxLimitado(l *global.QueryLimitsType) (*sql.DB, error) {
+	return sql.Open("duckdb", dsnLimites(l))
+}
+
+// contextoConsulta devuelve el contexto de ejecución de una consulta. Se
+// cancela al vencer el tiempo máximo o cuando el consumidor resetea el
+// stream. El fin normal de la escritura del consumidor (io.EOF) no cancela:
+// solo indica que ya envió la consulta.
+func contextoConsulta(s network.Stream, l *global.QueryLimitsType) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if _, err := io.Copy(io.Discard, s); err != nil {
+			cancel()
+		}
+	}()
+	ctxT, cancelT := plazoConsulta(ctx, l)
+	return ctxT, func() {
+		cancelT()
+		cancel()
+	}
+}
+
+// plazoConsulta aplica el tiempo máximo del DATA_SOURCE a una ejecución.
+func plazoConsulta(ctx context.Context, l *global.QueryLimitsType) (context.Context, context.CancelFunc) {
+	if l == nil || l.TimeoutSeconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(l.TimeoutSeconds)*time.Second)
+}
+
+// limiteFilas es el máximo de filas a enviar, o 0 si no hay límite.
+func limiteFilas(l *global.QueryLimitsType) int64 {
+	if l == nil || l.MaxRows <= 0 {
+		return 0
+	}
+	return l.MaxRows
+}
+
+func excesoFilas(max int64) *QueryError {
+	return &QueryError{Code: errRowLimit, Message: fmt.Sprintf("el resultado supera el máximo de %d filas", max)}
+}
+
+// escritorLimitado corta el resultado al superar MaxBytes.
+type escritorLimitado struct {
+	w     io.Writer
+	max   int64
+	total int64
+}
+
+func (e *escritorLimitado) Write(p []byte) (int, error) {
+	if e.total+int64(len(p)) > e.max {
+		return 0, &QueryError{Code: errByteLimit, Message: fmt.Sprintf("el resultado supera el máximo de %d bytes", e.max)}
+	}
+	e.total += int64(len(p))
+	return e.w.Write(p)
+}
+
+func escritorConLimite(w io.Writer, l *global.QueryLimitsType) io.Writer {
+	if l == nil || l.MaxBytes <= 0 {
+		return w
+	}
+	return &escritorLimitado{w: w, max: l.MaxBytes}
+}
+
+// errorEjecucion traduce el error de una consulta al código que viaja en el
+// cierre.
+func errorEjecucion(ctx context.Context, err error) QueryError {
+	var qe *QueryError
+	switch {
+	case errors.As(err, &qe):
+		return *qe
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return QueryError{Code: errTimeout, Message: "la consulta excedió el tiempo máximo"}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return QueryError{Code: errQueryCanceled, Message: "el consumidor canceló la consulta"}
+	case strings.Contains(err.Error(), "Out of Memory Error"):
+		return QueryError{Code: errMemoryLimit, Message: err.Error()}
+	}
+	return QueryError{Code: errQueryFailed, Message: err.Error()}
+}
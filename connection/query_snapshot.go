@@ -0,0 +1,144 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	global "Veredarii/global"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const intervaloInstantaneaDefecto = time.Hour
+
+// publicarInstantaneas agrega las instantáneas configuradas a los
+// DATA_SOURCE, para que se anuncien y se consulten como cualquier otra
+// fuente. Heredan los límites, la caché y el TTL de cursores de su origen;
+// las políticas son las propias de la instantánea.
+func (n *Network) publicarInstantaneas() {
+	origenes := append([]global.ResourceType(nil), n.Resources.DATASOURCE...)
+	for _, ds := range origenes {
+		for _, inst := range ds.Snapshots {
+			ruta, err := n.rutaInstantanea(inst)
+			if err != nil {
+				log.Error("Error preparando la instantánea ", inst.Name, ": ", err)
+				continue
+			}
+			n.Resources.DATASOURCE = append(n.Resources.DATASOURCE, global.ResourceType{
+				Name:             inst.Name,
+				Type:             global.ResourceTypeFile,
+				ResourcePath:     ruta,
+				Description:      inst.Description,
+				Policies:         inst.Policies,
+				Limits:           ds.Limits,
+				Cache:            ds.Cache,
+				CursorTTLSeconds: ds.CursorTTLSeconds,
+			})
+		}
+	}
+}
+
+// rutaInstantanea usa, si la instantánea no tiene ResourcePath, el
+// directorio privado del proceso.
+func (n *Network) rutaInstantanea(inst global.SnapshotType) (string, error) {
+	if inst.ResourcePath != "" {
+		return inst.ResourcePath, nil
+	}
+	dir, err := dirPrivado()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, n.Name+"-"+inst.Name+".parquet"), nil
+}
+
+// Instantaneas materializa cada instantánea al iniciar y luego cada
+// IntervalSeconds.
+func (n *Network) Instantaneas() {
+	for _, ds := range n.Resources.DATASOURCE {
+		for _, inst := range ds.Snapshots {
+			go n.programarInstantanea(ds, inst)
+		}
+	}
+}
+
+func (n *Network) programarInstantanea(ds global.ResourceType, inst global.SnapshotType) {
+	intervalo := intervaloInstantaneaDefecto
+	if inst.IntervalSeconds > 0 {
+		intervalo = time.Duration(inst.IntervalSeconds) * time.Second
+	}
+	ruta, err := n.rutaInstantanea(inst)
+	if err != nil {
+		log.Error("Error preparando la instantánea ", inst.Name, ": ", err)
+		return
+	}
+	for {
+		inicio := time.Now()
+		if filas, err := materializar(ds, inst.Query, ruta); err != nil {
+			log.Error("Error materializando ", inst.Name, ": ", err)
+		} else {
+			log.Infof("Instantánea %s actualizada: %d filas en %d ms", inst.Name, filas, time.Since(inicio).Milliseconds())
+		}
+		time.Sleep(intervalo)
+	}
+}
+
+// materializar escribe el resultado en un archivo temporal de nombre
+// aleatorio junto al destino y lo renombra, para que las consultas en curso
+// sigan leyendo la versión anterior.
+func materializar(ds global.ResourceType, consulta string, ruta string) (int64, error) {
+	db, err := abrirSandbox()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	origen, _, _, err := prepararOrigen(db, ds, nil)
+	if err != nil {
+		return 0, err
+	}
+	query := strings.ReplaceAll(consulta, "{{ORIGIN}}", origen)
+
+	f, err := os.CreateTemp(filepath.Dir(ruta), "."+filepath.Base(ruta)+"-*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("error creando instantánea: %w", err)
+	}
+	temporal := f.Name()
+	f.Close()
+	filas, err := exportToParquet(context.Background(), db, query, temporal)
+	if err != nil {
+		os.Remove(temporal)
+		return 0, err
+	}
+	if err := os.Rename(temporal, ruta); err != nil {
+		os.Remove(temporal)
+		return 0, fmt.Errorf("error publicando instantánea: %w", err)
+	}
+	return filas, nil
+}
@@ -0,0 +1,168 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	global "Veredarii/global"
+)
+
+const errInvalidParams = "INVALID_PARAMS"
+
+type TemplateSchema struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Params      []global.TemplateParamType `json:"params,omitempty"`
+}
+
+// consultaSolicitada devuelve el SQL a ejecutar y sus argumentos: la consulta
+// libre del cliente o la plantilla pedida con los parámetros ya convertidos a
+// su tipo declarado.
+func consultaSolicitada(ds global.ResourceType, q *QueryType) (string, []interface{}, *QueryError) {
+	if q.Template == "" {
+		if ds.DisableFreeSQL {
+			return "", nil, &QueryError{Code: errQueryRejected, Message: "la fuente de datos solo admite plantillas"}
+		}
+		return q.Query, nil, nil
+	}
+
+	var plantilla *global.QueryTemplateType
+	for i := range ds.Templates {
+		if ds.Templates[i].Name == q.Template {
+			plantilla = &ds.Templates[i]
+			break
+		}
+	}
+	if plantilla == nil {
+		return "", nil, &QueryError{Code: errDataSourceNotFound, Message: "plantilla no encontrada: " + q.Template}
+	}
+
+	declarados := make(map[string]bool, len(plantilla.Params))
+	args := make([]interface{}, 0, len(plantilla.Params))
+	for _, p := range plantilla.Params {
+		declarados[p.Name] = true
+		valor, ok := q.Params[p.Name]
+		if !ok || valor == nil {
+			valor = p.Default
+		}
+		if valor == nil && p.Required {
+			return "", nil, &QueryError{Code: errInvalidParams, Message: "falta el parámetro " + p.Name}
+		}
+		convertido, err := convertirParametro(p.Type, valor)
+		if err != nil {
+			return "", nil, &QueryError{Code: errInvalidParams, Message: fmt.Sprintf("parámetro %s: %v", p.Name, err)}
+		}
+		args = append(args, sql.Named(p.Name, convertido))
+	}
+	for nombre := range q.Params {
+		if !declarados[nombre] {
+			return "", nil, &QueryError{Code: errInvalidParams, Message: "parámetro desconocido: " + nombre}
+		}
+	}
+	return plantilla.Query, args, nil
+}
+
+func convertirParametro(tipo string, valor interface{}) (interface{}, error) {
+	if valor == nil {
+		return nil, nil
+	}
+	texto, esTexto := valor.(string)
+	switch tipo {
+	case "string":
+		if !esTexto {
+			return nil, fmt.Errorf("se esperaba texto")
+		}
+		return texto, nil
+	case "integer":
+		switch v := valor.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return i, nil
+			}
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v), nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("se esperaba un entero")
+	case "number":
+		switch v := valor.(type) {
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f, nil
+			}
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("se esperaba un número")
+	case "boolean":
+		if b, ok := valor.(bool); ok {
+			return b, nil
+		}
+		if b, err := strconv.ParseBool(texto); esTexto && err == nil {
+			return b, nil
+		}
+		return nil, fmt.Errorf("se esperaba un booleano")
+	case "date":
+		if !esTexto {
+			return nil, fmt.Errorf("se esperaba una fecha AAAA-MM-DD")
+		}
+		if _, err := time.Parse(time.DateOnly, texto); err != nil {
+			return nil, fmt.Errorf("se esperaba una fecha AAAA-MM-DD")
+		}
+		return texto, nil
+	case "timestamp":
+		if !esTexto {
+			return nil, fmt.Errorf("se esperaba una fecha RFC 3339")
+		}
+		t, err := time.Parse(time.RFC3339Nano, texto)
+		if err != nil {
+			return nil, fmt.Errorf("se esperaba una fecha RFC 3339")
+		}
+		return t.UTC().Format("2006-01-02 15:04:05.999999"), nil
+	}
+	return nil, fmt.Errorf("tipo no soportado: %s", tipo)
+}
+
+func esquemaPlantillas(ds global.ResourceType) []TemplateSchema {
+	var plantillas []TemplateSchema
+	for _, t := range ds.Templates {
+		plantillas = append(plantillas, TemplateSchema{Name: t.Name, Description: t.Description, Params: t.Params})
+	}
+	return plantillas
+}
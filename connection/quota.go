@@ -0,0 +1,171 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"sync"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+)
+
+var cuotas = &QuotaManager{contadores: make(map[string]*contadorCuota)}
+
+// QuotaManager lleva el consumo por (entidad, red, servicio): una tasa de
+// peticiones por segundo con ráfaga y topes diarios de peticiones y de bytes
+// enviados.
+type QuotaManager struct {
+	sync.Mutex
+	contadores map[string]*contadorCuota
+}
+
+type contadorCuota struct {
+	limiter *rate.Limiter
+	tasa    float64
+	rafaga  int
+	dia     string
+	usadas  int
+	bytes   int64
+}
+
+// Permitir descuenta una petición. Si no hay cupo devuelve false y el tiempo
+// tras el cual conviene reintentar.
+func (q *QuotaManager) Permitir(entidad string, red string, servicio string, cuota global.QuotaType) (bool, time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+
+	clave := claveCuota(entidad, red, servicio)
+	c, ok := q.contadores[clave]
+	if !ok {
+		c = &contadorCuota{}
+		q.contadores[clave] = c
+	}
+	// Si la configuración cambió se rehace el limitador; el consumo del día
+	// se conserva.
+	burst := cuota.Burst
+	if burst <= 0 {
+		burst = max(1, int(cuota.RequestsPerSecond))
+	}
+	if !ok || c.tasa != cuota.RequestsPerSecond || c.rafaga != burst {
+		c.tasa, c.rafaga, c.limiter = cuota.RequestsPerSecond, burst, nil
+		if cuota.RequestsPerSecond > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(cuota.RequestsPerSecond), burst)
+		}
+	}
+
+	ahora := time.Now()
+	c.renovarDia(ahora)
+	if (cuota.DailyRequests > 0 && c.usadas >= cuota.DailyRequests) || (cuota.DailyBytes > 0 && c.bytes >= cuota.DailyBytes) {
+		manana := time.Date(ahora.Year(), ahora.Month(), ahora.Day()+1, 0, 0, 0, 0, ahora.Location())
+		return false, manana.Sub(ahora)
+	}
+
+	if c.limiter != nil {
+		r := c.limiter.ReserveN(ahora, 1)
+		if !r.OK() {
+			return false, time.Second
+		}
+		if espera := r.DelayFrom(ahora); espera > 0 {
+			r.CancelAt(ahora)
+			return false, espera
+		}
+	}
+
+	c.usadas++
+	return true, 0
+}
+
+// Consumir suma bytes enviados al consumo del día. El tope se revisa al
+// admitir cada petición: la que lo cruza termina de enviarse.
+func (q *QuotaManager) Consumir(entidad string, red string, servicio string, bytes int64) {
+	q.Lock()
+	defer q.Unlock()
+	if c, ok := q.contadores[claveCuota(entidad, red, servicio)]; ok {
+		c.renovarDia(time.Now())
+		c.bytes += bytes
+	}
+}
+
+func claveCuota(entidad string, red string, servicio string) string {
+	return entidad + "|" + red + "|" + servicio
+}
+
+func (c *contadorCuota) renovarDia(ahora time.Time) {
+	if hoy := ahora.Format(time.DateOnly); c.dia != hoy {
+		c.dia = hoy
+		c.usadas = 0
+		c.bytes = 0
+	}
+}
+
+// permitirCuota aplica la cuota del recurso a la entidad del peer remoto.
+func (n *Network) permitirCuota(remotePeer peer.ID, resource global.ResourceType) (bool, time.Duration) {
+	if resource.Quota == nil {
+		return true, 0
+	}
+	entidad := RBAC.Entity(remotePeer)
+	return cuotas.Permitir(entidad, n.Name, resource.Name, cuotaEntidad(resource, entidad))
+}
+
+func cuotaEntidad(resource global.ResourceType, entidad string) global.QuotaType {
+	if particular, ok := resource.Quota.Entities[entidad]; ok {
+		return particular
+	}
+	return *resource.Quota
+}
+
+// medirCuota devuelve s contando lo que se escribe en él para el tope diario
+// de bytes del recurso, o el mismo s si el recurso no tiene ese tope.
+func (n *Network) medirCuota(s network.Stream, remotePeer peer.ID, resource global.ResourceType) network.Stream {
+	if resource.Quota == nil {
+		return s
+	}
+	entidad := RBAC.Entity(remotePeer)
+	if cuotaEntidad(resource, entidad).DailyBytes <= 0 {
+		return s
+	}
+	return &streamMedido{Stream: s, consumir: func(bytes int64) {
+		cuotas.Consumir(entidad, n.Name, resource.Name, bytes)
+	}}
+}
+
+type streamMedido struct {
+	network.Stream
+	consumir func(int64)
+}
+
+func (s *streamMedido) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.consumir(int64(n))
+	return n, err
+}
+
+// segundosReintento redondea hacia arriba para encabezados Retry-After.
+func segundosReintento(espera time.Duration) int {
+	return int((espera + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,27 @@
+package connection
+
+import (
+	"testing"
+
+	global "Veredarii/global"
+)
+
+func TestCuotaBytesDiarios(t *testing.T) {
+	q := &QuotaManager{contadores: make(map[string]*contadorCuota)}
+	cuota := global.QuotaType{DailyBytes: 100}
+
+	if ok, _ := q.Permitir("e", "red", "ds", cuota); !ok {
+		t.Fatal("la primera petición debería admitirse")
+	}
+	q.Consumir("e", "red", "ds", 60)
+	if ok, _ := q.Permitir("e", "red", "ds", cuota); !ok {
+		t.Fatal("con 60 de 100 bytes debería admitirse")
+	}
+	q.Consumir("e", "red", "ds", 60)
+	if ok, espera := q.Permitir("e", "red", "ds", cuota); ok || espera <= 0 {
+		t.Errorf("pasado el tope debería rechazarse hasta mañana, espera %v", espera)
+	}
+	if ok, _ := q.Permitir("otra", "red", "ds", cuota); !ok {
+		t.Error("el consumo es por entidad")
+	}
+}
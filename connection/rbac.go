@@ -67,7 +67,7 @@ func (rb *RBACType) Allowed(peerID peer.ID, dom string, obj string, act string)
 
 func (rb *RBACType) HasPermition2Protocol(peerID peer.ID, dom string, obj string) bool {
 	if entity := rb.PeerEntity[peerID.String()]; entity == "" {
-		log.Debug(fmt.Sprintf("No encontrado: %s %s %s %s", peerID.String(), dom, obj))
+		log.Debug(fmt.Sprintf("No encontrado: %s %s %s", peerID.String(), dom, obj))
 		return false
 	} else {
 		policies, err := rb.Enforcer.GetFilteredPolicy(0, entity, dom, obj)
@@ -83,6 +83,12 @@ func (rb *RBACType) HasPermition2Protocol(peerID peer.ID, dom string, obj string
 	}
 }
 
+func (rb *RBACType) Entity(peerID peer.ID) string {
+	rb.MutexSesiones.RLock()
+	defer rb.MutexSesiones.RUnlock()
+	return rb.PeerEntity[peerID.String()]
+}
+
 func (rb *RBACType) SetPeer(rec EntidadRecord) {
 	rb.MutexSesiones.Lock()
 	defer rb.MutexSesiones.Unlock()
@@ -0,0 +1,211 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Para los clientes SQL que no conocen remote(), cada DATA_SOURCE remoto se
+// nombra como la tabla red.fuente: un esquema por red.
+
+// dsnCatalogo es el sandbox sin acceso a archivos: el catálogo solo tiene
+// tablas vacías y las vistas de information_schema y pg_catalog.
+const dsnCatalogo = dsnSandbox + "&enable_external_access=false"
+
+const vigenciaCatalogo = 5 * time.Minute
+
+// catalogoRemoto guarda las columnas de las fuentes remotas por red y
+// fuente, para no pedir el esquema a los proveedores en cada conexión.
+type catalogoRemoto struct {
+	mu       sync.Mutex
+	columnas map[[2]string][]ColumnSchema
+	vence    time.Time
+}
+
+var catalogo catalogoRemoto
+
+// TablasRemotas reemplaza cada tabla red.fuente de la consulta que sea un
+// DATA_SOURCE remoto por remote('red', 'fuente'), para ejecutarla con
+// RecorrerDistribuida. Devuelve cuántas tablas reemplazó; sin reemplazos la
+// consulta vuelve igual.
+func TablasRemotas(query string) (string, int, error) {
+	db, err := abrirSandbox()
+	if err != nil {
+		return "", 0, err
+	}
+	defer db.Close()
+
+	arbol, err := analizarSQL(db, query)
+	if err != nil {
+		return "", 0, &QueryError{Code: errQueryRejected, Message: err.Error()}
+	}
+	reemplazos := 0
+	err = recorrerArbol(arbol, func(nodo map[string]interface{}) error {
+		if nodo["type"] != "BASE_TABLE" {
+			return nil
+		}
+		esquema, _ := nodo["schema_name"].(string)
+		tabla, _ := nodo["table_name"].(string)
+		red, ds, ok := fuenteRemota(esquema, tabla)
+		if !ok {
+			return nil
+		}
+		funcion, err := analizarSQL(db, fmt.Sprintf("SELECT * FROM %s(%s, %s)", funcionRemota, literal(red), literal(ds)))
+		if err != nil {
+			return err
+		}
+		ref, _ := funcion.(map[string]interface{})["node"].(map[string]interface{})["from_table"].(map[string]interface{})
+		// Sin alias, la consulta califica las columnas con el nombre de la
+		// tabla.
+		alias, _ := nodo["alias"].(string)
+		if alias == "" {
+			alias = tabla
+		}
+		ref["alias"] = alias
+		ref["column_name_alias"] = nodo["column_name_alias"]
+		for clave := range nodo {
+			delete(nodo, clave)
+		}
+		for clave, valor := range ref {
+			nodo[clave] = valor
+		}
+		reemplazos++
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if reemplazos == 0 {
+		return query, 0, nil
+	}
+	final, err := generarSQL(db, arbol)
+	return final, reemplazos, err
+}
+
+func fuenteRemota(esquema, tabla string) (string, string, bool) {
+	if esquema == "" || NM == nil {
+		return "", "", false
+	}
+	for nombre, n := range NM.Networks {
+		if !strings.EqualFold(nombre, esquema) {
+			continue
+		}
+		for _, ds := range n.RemoteResources.DATASOURCE {
+			if strings.EqualFold(ds.Name, tabla) {
+				return nombre, ds.Name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// AbrirCatalogo devuelve una base con un esquema por red y una tabla vacía
+// por cada DATA_SOURCE remoto cuyo proveedor respondió, para atender las
+// consultas de catálogo de los clientes.
+func AbrirCatalogo(ctx context.Context) (*sql.DB, error) {
+	columnas := catalogo.obtener(ctx)
+	db, err := sql.Open("duckdb", dsnCatalogo)
+	if err != nil {
+		return nil, err
+	}
+
+	claves := make([][2]string, 0, len(columnas))
+	for clave := range columnas {
+		claves = append(claves, clave)
+	}
+	sort.Slice(claves, func(i, j int) bool {
+		return claves[i][0]+"."+claves[i][1] < claves[j][0]+"."+claves[j][1]
+	})
+	esquemas := map[string]bool{}
+	for _, clave := range claves {
+		if !esquemas[clave[0]] {
+			if _, err := db.ExecContext(ctx, "CREATE SCHEMA "+identificador(clave[0])); err != nil {
+				db.Close()
+				return nil, err
+			}
+			esquemas[clave[0]] = true
+		}
+		definicion := make([]string, len(columnas[clave]))
+		for i, c := range columnas[clave] {
+			definicion[i] = identificador(c.Name) + " " + c.Type
+		}
+		tabla := identificador(clave[0]) + "." + identificador(clave[1])
+		if _, err := db.ExecContext(ctx, "CREATE TABLE "+tabla+" ("+strings.Join(definicion, ", ")+")"); err != nil {
+			log.Warn("No se pudo publicar ", tabla, " en el catálogo: ", err)
+		}
+	}
+	return db, nil
+}
+
+func (c *catalogoRemoto) obtener(ctx context.Context) map[[2]string][]ColumnSchema {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.columnas != nil && time.Now().Before(c.vence) {
+		return c.columnas
+	}
+
+	columnas := map[[2]string][]ColumnSchema{}
+	if NM == nil {
+		return columnas
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for nombre, n := range NM.Networks {
+		for _, ds := range n.RemoteResources.DATASOURCE {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				targetID := n.BuscarServicio(ctx, ds.Name)
+				if targetID == "" {
+					log.Warn("Fuente remota sin proveedor para el catálogo: ", nombre, "/", ds.Name)
+					return
+				}
+				esquemas, err := n.Esquema(targetID, ds.Name)
+				if err != nil || len(esquemas) == 0 || len(esquemas[0].Columns) == 0 {
+					log.Warn("No se pudo describir ", nombre, "/", ds.Name, ": ", err)
+					return
+				}
+				mu.Lock()
+				columnas[[2]string{nombre, ds.Name}] = esquemas[0].Columns
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	// Un catálogo vacío no se guarda: puede que los proveedores todavía no
+	// estén conectados.
+	if len(columnas) > 0 {
+		c.columnas, c.vence = columnas, time.Now().Add(vigenciaCatalogo)
+	}
+	return columnas
+}
@@ -0,0 +1,291 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	global "Veredarii/global"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	entradasCacheDefecto = 1000
+	bytesCacheDefecto    = 64 << 20
+)
+
+var ErrServicioNoEncontrado = errors.New("servicio no encontrado")
+
+// ResponseCache guarda respuestas GET de un servicio remoto según lo que
+// indique el propio servicio (Cache-Control, Expires, ETag, Last-Modified) y
+// descarta las menos usadas al superar los límites.
+type ResponseCache struct {
+	mu          sync.Mutex
+	maxEntradas int
+	maxBytes    int64
+	bytes       int64
+	lru         *list.List
+	entradas    map[string]*list.Element
+}
+
+type respuestaCache struct {
+	clave    string
+	status   int
+	header   http.Header
+	body     []byte
+	guardada time.Time
+	expira   time.Time
+}
+
+func NewResponseCache(conf *global.CacheType) *ResponseCache {
+	c := &ResponseCache{
+		maxEntradas: entradasCacheDefecto,
+		maxBytes:    bytesCacheDefecto,
+		lru:         list.New(),
+		entradas:    make(map[string]*list.Element),
+	}
+	if conf.MaxEntries > 0 {
+		c.maxEntradas = conf.MaxEntries
+	}
+	if conf.MaxBytes > 0 {
+		c.maxBytes = conf.MaxBytes
+	}
+	return c
+}
+
+func (c *ResponseCache) obtener(clave string) *respuestaCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entradas[clave]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*respuestaCache)
+}
+
+func (c *ResponseCache) guardar(r *respuestaCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(r.body)) > c.maxBytes {
+		return
+	}
+	if elem, ok := c.entradas[r.clave]; ok {
+		c.bytes -= int64(len(elem.Value.(*respuestaCache).body))
+		c.lru.Remove(elem)
+	}
+	c.entradas[r.clave] = c.lru.PushFront(r)
+	c.bytes += int64(len(r.body))
+
+	for c.lru.Len() > c.maxEntradas || c.bytes > c.maxBytes {
+		ultima := c.lru.Back()
+		vieja := ultima.Value.(*respuestaCache)
+		c.lru.Remove(ultima)
+		delete(c.entradas, vieja.clave)
+		c.bytes -= int64(len(vieja.body))
+	}
+}
+
+func (c *ResponseCache) borrar(clave string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entradas[clave]; ok {
+		c.bytes -= int64(len(elem.Value.(*respuestaCache).body))
+		c.lru.Remove(elem)
+		delete(c.entradas, clave)
+	}
+}
+
+func (n *Network) cacheDe(resource global.ResourceType) *ResponseCache {
+	n.mutexCaches.Lock()
+	defer n.mutexCaches.Unlock()
+	c, ok := n.Caches[resource.Name]
+	if !ok {
+		c = NewResponseCache(resource.Cache)
+		n.Caches[resource.Name] = c
+	}
+	return c
+}
+
+// ConversarCacheado atiende una petición GET a un servicio remoto con caché.
+// Una respuesta vigente se entrega sin salir a la red; una vencida con ETag o
+// Last-Modified se revalida con el proveedor, que puede contestar 304.
+func (n *Network) ConversarCacheado(resource global.ResourceType, r *http.Request, w http.ResponseWriter) error {
+	c := n.cacheDe(resource)
+	clave := n.Name + "|" + resource.Name + "|" + r.URL.Path + "?" + r.URL.RawQuery
+	cliente := parseCacheControl(r.Header.Get("Cache-Control"))
+
+	entrada := c.obtener(clave)
+	if entrada != nil && time.Now().Before(entrada.expira) && !cliente.noCache {
+		entrada.escribir(w, "HIT")
+		return nil
+	}
+
+	targetID := n.BuscarServicio(context.Background(), resource.Name)
+	if targetID == "" {
+		return ErrServicioNoEncontrado
+	}
+	return n.consultarCacheado(targetID, resource.Name, c, clave, entrada, r, w)
+}
+
+func (n *Network) consultarCacheado(targetID peer.ID, service string, c *ResponseCache, clave string, entrada *respuestaCache, r *http.Request, w http.ResponseWriter) error {
+	peticion := r.Clone(r.Context())
+	if entrada != nil {
+		if etag := entrada.header.Get("ETag"); etag != "" {
+			peticion.Header.Set("If-None-Match", etag)
+		}
+		if modificado := entrada.header.Get("Last-Modified"); modificado != "" {
+			peticion.Header.Set("If-Modified-Since", modificado)
+		}
+	}
+	payload, err := httputil.DumpRequest(peticion, true)
+	if err != nil {
+		return fmt.Errorf("error capturando petición: %w", err)
+	}
+
+	s, br, res, meta, err := n.enviarAPI(targetID, service, payload)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if meta.Mode != "" {
+		return escribirRespuestaAPI(s, br, res, meta, w)
+	}
+
+	ahora := time.Now()
+	if meta.Status == http.StatusNotModified && entrada != nil {
+		renovada := *entrada
+		renovada.header = entrada.header.Clone()
+		for _, k := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date"} {
+			if v := meta.Header.Get(k); v != "" {
+				renovada.header.Set(k, v)
+			}
+		}
+		renovada.guardada = ahora
+		renovada.expira = ahora.Add(frescura(renovada.header, ahora))
+		c.guardar(&renovada)
+		renovada.escribir(w, "REVALIDATED")
+		return nil
+	}
+
+	if meta.Status == http.StatusOK && r.Header.Get("Authorization") == "" && meta.Header.Get("Vary") == "" {
+		servicio := parseCacheControl(meta.Header.Get("Cache-Control"))
+		validable := meta.Header.Get("ETag") != "" || meta.Header.Get("Last-Modified") != ""
+		vigencia := frescura(meta.Header, ahora)
+		if !servicio.noStore && !servicio.private && (vigencia > 0 || validable) {
+			c.guardar(&respuestaCache{
+				clave:    clave,
+				status:   meta.Status,
+				header:   meta.Header,
+				body:     res.Payload,
+				guardada: ahora,
+				expira:   ahora.Add(vigencia),
+			})
+		} else {
+			c.borrar(clave)
+		}
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	return escribirRespuestaAPI(s, br, res, meta, w)
+}
+
+func (r *respuestaCache) escribir(w http.ResponseWriter, estado string) {
+	for k, v := range r.header {
+		if k == "Connection" {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(r.guardada).Seconds())))
+	w.Header().Set("X-Cache", estado)
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+type cacheControl struct {
+	noStore bool
+	noCache bool
+	private bool
+	maxAge  int
+	sMaxAge int
+}
+
+func parseCacheControl(valor string) cacheControl {
+	cc := cacheControl{maxAge: -1, sMaxAge: -1}
+	for _, directiva := range strings.Split(valor, ",") {
+		nombre, arg, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directiva)), "=")
+		switch nombre {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "private":
+			cc.private = true
+		case "max-age":
+			if v, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil {
+				cc.maxAge = v
+			}
+		case "s-maxage":
+			if v, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil {
+				cc.sMaxAge = v
+			}
+		}
+	}
+	return cc
+}
+
+// frescura calcula cuánto tiempo es válida una respuesta sin revalidar. La
+// caché es compartida por los clientes locales, así que s-maxage tiene
+// prioridad sobre max-age.
+func frescura(header http.Header, ahora time.Time) time.Duration {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	switch {
+	case cc.noCache:
+		return 0
+	case cc.sMaxAge >= 0:
+		return time.Duration(cc.sMaxAge) * time.Second
+	case cc.maxAge >= 0:
+		return time.Duration(cc.maxAge) * time.Second
+	}
+	if expira, err := http.ParseTime(header.Get("Expires")); err == nil {
+		if fecha, err := http.ParseTime(header.Get("Date")); err == nil {
+			ahora = fecha
+		}
+		if d := expira.Sub(ahora); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,333 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	global "Veredarii/global"
+)
+
+// ResultCache guarda resultados completos de consultas a un DATA_SOURCE. La
+// clave incluye la versión de la fuente, así que un cambio en los archivos
+// deja de encontrar las entradas viejas, que salen por LRU o por TTL.
+type ResultCache struct {
+	mu          sync.Mutex
+	maxEntradas int
+	maxBytes    int64
+	ttl         time.Duration
+	bytes       int64
+	lru         *list.List
+	entradas    map[string]*list.Element
+}
+
+type resultadoCache struct {
+	clave    string
+	columnas []ColumnSchema
+	bloques  [][]byte
+	filas    int64
+	bytes    int64
+	expira   time.Time
+}
+
+func NewResultCache(conf *global.CacheType) *ResultCache {
+	c := &ResultCache{
+		maxEntradas: entradasCacheDefecto,
+		maxBytes:    bytesCacheDefecto,
+		lru:         list.New(),
+		entradas:    make(map[string]*list.Element),
+	}
+	if conf.MaxEntries > 0 {
+		c.maxEntradas = conf.MaxEntries
+	}
+	if conf.MaxBytes > 0 {
+		c.maxBytes = conf.MaxBytes
+	}
+	c.ttl = time.Duration(conf.TTLSeconds) * time.Second
+	return c
+}
+
+func (c *ResultCache) obtener(clave string) *resultadoCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entradas[clave]
+	if !ok {
+		return nil
+	}
+	r := elem.Value.(*resultadoCache)
+	if !r.expira.IsZero() && time.Now().After(r.expira) {
+		c.quitar(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return r
+}
+
+func (c *ResultCache) guardar(r *resultadoCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.bytes > c.maxBytes {
+		return
+	}
+	if c.ttl > 0 {
+		r.expira = time.Now().Add(c.ttl)
+	}
+	if elem, ok := c.entradas[r.clave]; ok {
+		c.quitar(elem)
+	}
+	c.entradas[r.clave] = c.lru.PushFront(r)
+	c.bytes += r.bytes
+
+	for c.lru.Len() > c.maxEntradas || c.bytes > c.maxBytes {
+		c.quitar(c.lru.Back())
+	}
+}
+
+func (c *ResultCache) quitar(elem *list.Element) {
+	r := elem.Value.(*resultadoCache)
+	c.lru.Remove(elem)
+	delete(c.entradas, r.clave)
+	c.bytes -= r.bytes
+}
+
+func (n *Network) cacheResultados(ds global.ResourceType) *ResultCache {
+	if ds.Cache == nil {
+		return nil
+	}
+	n.mutexResultCaches.Lock()
+	defer n.mutexResultCaches.Unlock()
+	c, ok := n.ResultCaches[ds.Name]
+	if !ok {
+		c = NewResultCache(ds.Cache)
+		n.ResultCaches[ds.Name] = c
+	}
+	return c
+}
+
+// claveResultado identifica un resultado por la consulta normalizada por el
+// parser de DuckDB, sus parámetros, el formato pedido, la versión de la
+// fuente y la política efectiva del consumidor. Devuelve "" si el resultado
+// no se puede cachear: sin versión de la fuente, solo vale con TTL.
+func claveResultado(db *sql.DB, ds global.ResourceType, p *global.DataPolicyType, query string, args []interface{}, queryType *QueryType) string {
+	version := versionFuente(ds)
+	if version == "" && ds.Cache.TTLSeconds <= 0 {
+		return ""
+	}
+	arbol, err := analizarSQL(db, query)
+	if err != nil {
+		return ""
+	}
+	if !determinista(arbol) {
+		return ""
+	}
+	normal, err := generarSQL(db, arbol)
+	if err != nil {
+		return ""
+	}
+	politica, _ := json.Marshal(p)
+	params, _ := json.Marshal(args)
+
+	h := sha256.New()
+	for _, parte := range []string{normal, string(params), queryType.Format, fmt.Sprint(queryType.BlockSize), version, string(politica)} {
+		io.WriteString(h, parte)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// funcionesTemporales dependen del momento de la consulta; junto con
+// funcionesVolatiles impiden cachear el resultado.
+var funcionesTemporales = map[string]bool{
+	"now":                   true,
+	"today":                 true,
+	"current_date":          true,
+	"current_time":          true,
+	"current_timestamp":     true,
+	"get_current_time":      true,
+	"get_current_timestamp": true,
+	"transaction_timestamp": true,
+}
+
+func determinista(arbol interface{}) bool {
+	err := recorrerArbol(arbol, func(nodo map[string]interface{}) error {
+		switch nodo["class"] {
+		case "FUNCTION":
+			nombre, _ := nodo["function_name"].(string)
+			nombre = strings.ToLower(nombre)
+			if funcionesVolatiles[nombre] || funcionesTemporales[nombre] {
+				return errNoDelegable
+			}
+		case "COLUMN_REF":
+			// current_date y similares llegan como columnas sin tabla.
+			nombres := nombresColumna(nodo)
+			if len(nombres) == 1 && funcionesTemporales[strings.ToLower(nombres[0])] {
+				return errNoDelegable
+			}
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// versionFuente resume el estado de los archivos del DATA_SOURCE: tamaño y
+// fecha de modificación o, con Validate "hash", el contenido. Las bases de
+// datos remotas y los objetos HTTP/S3 no tienen versión.
+func versionFuente(ds global.ResourceType) string {
+	var ruta string
+	switch ds.Type {
+	case "", global.ResourceTypeFile, global.ResourceTypeSQLite:
+		ruta = ds.ResourcePath
+	default:
+		return ""
+	}
+	archivos, err := filepath.Glob(ruta)
+	if err != nil || len(archivos) == 0 {
+		return ""
+	}
+	sort.Strings(archivos)
+
+	h := sha256.New()
+	for _, archivo := range archivos {
+		info, err := os.Stat(archivo)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "%s|%d|%d\n", archivo, info.Size(), info.ModTime().UnixNano())
+		if ds.Cache != nil && ds.Cache.Validate == "hash" {
+			resumen, err := resumenesFuente.resumen(archivo, info)
+			if err != nil {
+				return ""
+			}
+			fmt.Fprintf(h, "%s\n", resumen)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const maxResumenesFuente = 4096
+
+// resumenesFuente recuerda el contenido de los archivos validados por hash
+// mientras no cambian su tamaño, su fecha ni su inodo, para no releerlos en
+// cada consulta ni en cada revisión de las suscripciones.
+var resumenesFuente = nuevoCacheResumenes(maxResumenesFuente)
+
+type resumenArchivo struct {
+	size    int64
+	modTime time.Time
+	inodo   uint64
+	cambio  int64
+	hash    string
+}
+
+// cacheResumenes guarda el SHA-256 de hasta max archivos; al llenarse
+// descarta una entrada cualquiera.
+type cacheResumenes struct {
+	mu       sync.Mutex
+	max      int
+	entradas map[string]resumenArchivo
+}
+
+func nuevoCacheResumenes(max int) *cacheResumenes {
+	return &cacheResumenes{max: max, entradas: map[string]resumenArchivo{}}
+}
+
+func (c *cacheResumenes) resumen(ruta string, info os.FileInfo) (string, error) {
+	inodo, cambio := inodoArchivo(info)
+	c.mu.Lock()
+	r, ok := c.entradas[ruta]
+	c.mu.Unlock()
+	if ok && r.size == info.Size() && r.modTime.Equal(info.ModTime()) && r.inodo == inodo && r.cambio == cambio {
+		return r.hash, nil
+	}
+
+	f, err := os.Open(ruta)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	r = resumenArchivo{size: info.Size(), modTime: info.ModTime(), inodo: inodo, cambio: cambio, hash: hex.EncodeToString(h.Sum(nil))}
+
+	c.mu.Lock()
+	if _, existe := c.entradas[ruta]; !existe && len(c.entradas) >= c.max {
+		for vieja := range c.entradas {
+			delete(c.entradas, vieja)
+			break
+		}
+	}
+	c.entradas[ruta] = r
+	c.mu.Unlock()
+	return r.hash, nil
+}
+
+// grabadorResultado copia lo que se envía al consumidor mientras no supere
+// max bytes, para guardarlo en la caché al terminar.
+type grabadorResultado struct {
+	w          io.Writer
+	max        int64
+	bloques    [][]byte
+	bytes      int64
+	desbordado bool
+}
+
+func (g *grabadorResultado) Write(p []byte) (int, error) {
+	n, err := g.w.Write(p)
+	if err != nil || g.desbordado {
+		return n, err
+	}
+	if g.bytes+int64(len(p)) > g.max {
+		g.desbordado, g.bloques = true, nil
+		return n, err
+	}
+	g.bloques = append(g.bloques, append([]byte(nil), p...))
+	g.bytes += int64(len(p))
+	return n, err
+}
+
+func (e *emisorQuery) enviarCacheado(r *resultadoCache) {
+	if err := e.enviarCabecera(r.columnas); err != nil {
+		return
+	}
+	for _, bloque := range r.bloques {
+		if _, err := e.Write(bloque); err != nil {
+			return
+		}
+	}
+	e.cerrar(QueryTrailer{Status: estadoOK, Rows: r.filas, Cached: true})
+}
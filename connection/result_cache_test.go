@@ -0,0 +1,41 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Un archivo reescrito con el mismo tamaño y la misma fecha no devuelve el
+// resumen anterior.
+func TestCacheResumenesMismaFecha(t *testing.T) {
+	ruta := filepath.Join(t.TempDir(), "datos.csv")
+	if err := os.WriteFile(ruta, []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := nuevoCacheResumenes(10)
+	info, _ := os.Stat(ruta)
+	antes, err := c.resumen(ruta, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otra, _ := c.resumen(ruta, info); otra != antes {
+		t.Fatal("sin cambios el resumen debería repetirse")
+	}
+
+	if err := os.WriteFile(ruta, []byte("a,b\n1,3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(ruta, info.ModTime(), info.ModTime())
+	nuevo, _ := os.Stat(ruta)
+	if nuevo.Size() != info.Size() || !nuevo.ModTime().Equal(info.ModTime()) {
+		t.Fatal("el archivo debería conservar tamaño y fecha")
+	}
+	despues, err := c.resumen(ruta, nuevo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if despues == antes {
+		t.Error("el resumen no cambió con el contenido")
+	}
+}
@@ -0,0 +1,242 @@
+package connection
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// La configuración se fija al abrir la base y queda bloqueada: la consulta del
+// cliente no puede cargar extensiones ni cambiar opciones.
+const dsnSandbox = "?autoinstall_known_extensions=false" +
+	"&autoload_known_extensions=false" +
+	"&allow_community_extensions=false" +
+	"&lock_configuration=true"
+
+// Funciones de tabla que leen archivos. Solo se aceptan con la ruta del
+// recurso como primer argumento.
+var lectoresPermitidos = map[string]bool{
+	"read_csv":          true,
+	"read_csv_auto":     true,
+	"read_parquet":      true,
+	"parquet_scan":      true,
+	"read_json":         true,
+	"read_json_auto":    true,
+	"read_ndjson":       true,
+	"read_ndjson_auto":  true,
+	"read_json_objects": true,
+}
+
+// Funciones de tabla que no tocan el sistema de archivos.
+var funcionesTablaPermitidas = map[string]bool{
+	"range":           true,
+	"generate_series": true,
+	"unnest":          true,
+}
+
+var funcionesDenegadas = map[string]bool{
+	"getenv":           true,
+	funcionEnmascarado: true,
+}
+
+func abrirSandbox() (*sql.DB, error) {
+	return sql.Open("duckdb", dsnSandbox)
+}
+
+// validarConsulta acepta una única sentencia SELECT que solo lea de las vistas
+// preparadas por el proveedor, de los archivos del recurso y de sus propios
+// CTE. El análisis se hace sobre el árbol que devuelve el parser de DuckDB,
+// no sobre el texto. Un nombre de tabla solo se toma como CTE si hay uno con
+// ese nombre visible en ese punto de la consulta.
+func validarConsulta(db *sql.DB, query string, vistas []string, archivos []string) error {
+	arbol, err := analizarSQL(db, query)
+	if err != nil {
+		return err
+	}
+
+	tablas := map[string]bool{}
+	rutas := map[string]bool{}
+	for _, v := range vistas {
+		tablas[v] = true
+	}
+	for _, a := range archivos {
+		tablas[a] = true
+		rutas[a] = true
+	}
+
+	return recorrerAmbito(arbol, nil, func(nodo map[string]interface{}, ctes map[string]bool) error {
+		tipo, _ := nodo["type"].(string)
+		switch {
+		case tipo == "BASE_TABLE":
+			nombre, _ := nodo["table_name"].(string)
+			esquema, _ := nodo["schema_name"].(string)
+			catalogo, _ := nodo["catalog_name"].(string)
+			if esquema == "" && catalogo == "" && (tablas[nombre] || ctes[nombre]) {
+				return nil
+			}
+			return fmt.Errorf("acceso no permitido a la tabla %q", nombre)
+		case tipo == "TABLE_FUNCTION":
+			funcion, _ := nodo["function"].(map[string]interface{})
+			nombre, _ := funcion["function_name"].(string)
+			nombre = strings.ToLower(nombre)
+			if funcionesTablaPermitidas[nombre] {
+				return nil
+			}
+			if !lectoresPermitidos[nombre] {
+				return fmt.Errorf("función de tabla no permitida: %s", nombre)
+			}
+			hijos, _ := funcion["children"].([]interface{})
+			if len(hijos) == 0 || !rutas[valorConstante(hijos[0])] {
+				return fmt.Errorf("%s solo puede leer la fuente de datos publicada", nombre)
+			}
+		case nodo["class"] == "FUNCTION":
+			nombre, _ := nodo["function_name"].(string)
+			if funcionesDenegadas[strings.ToLower(nombre)] {
+				return fmt.Errorf("función no permitida: %s", nombre)
+			}
+		}
+		return nil
+	})
+}
+
+// analizarSQL devuelve el árbol de la única sentencia de query. Los números se
+// conservan como json.Number para no perder precisión al volver a SQL.
+func analizarSQL(db *sql.DB, query string) (interface{}, error) {
+	var arbol string
+	if err := db.QueryRow("SELECT json_serialize_sql(?::VARCHAR)::VARCHAR", query).Scan(&arbol); err != nil {
+		return nil, fmt.Errorf("error analizando consulta: %w", err)
+	}
+	var res struct {
+		Error        bool          `json:"error"`
+		ErrorMessage string        `json:"error_message"`
+		Statements   []interface{} `json:"statements"`
+	}
+	dec := json.NewDecoder(strings.NewReader(arbol))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("error analizando consulta: %w", err)
+	}
+	if res.Error {
+		if strings.Contains(res.ErrorMessage, "Only SELECT") {
+			return nil, fmt.Errorf("solo se permiten consultas SELECT")
+		}
+		return nil, fmt.Errorf("consulta inválida: %s", res.ErrorMessage)
+	}
+	if len(res.Statements) != 1 {
+		return nil, fmt.Errorf("se permite una sola sentencia por consulta")
+	}
+	return res.Statements[0], nil
+}
+
+// recorrerAmbito visita el árbol como recorrerArbol y entrega a cada nodo los
+// CTE visibles en él: los de su propio WITH y los de las consultas que lo
+// contienen. Un CTE declarado dentro de una subconsulta no cubre las tablas
+// de fuera de ella.
+func recorrerAmbito(v interface{}, ctes map[string]bool, visitar func(map[string]interface{}, map[string]bool) error) error {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		ctes = ambitoCTE(x, ctes)
+		if err := visitar(x, ctes); err != nil {
+			return err
+		}
+		for _, hijo := range x {
+			if err := recorrerAmbito(hijo, ctes, visitar); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, hijo := range x {
+			if err := recorrerAmbito(hijo, ctes, visitar); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ambitoCTE agrega a ctes los CTE que declara el nodo, en una copia para no
+// extenderlos a los nodos hermanos. DuckDB deja que un CTE nombre a los demás
+// del mismo WITH, así que todos cubren también las definiciones.
+func ambitoCTE(nodo map[string]interface{}, ctes map[string]bool) map[string]bool {
+	var nombres []string
+	cteMap, _ := nodo["cte_map"].(map[string]interface{})
+	entradas, _ := cteMap["map"].([]interface{})
+	for _, e := range entradas {
+		if e, ok := e.(map[string]interface{}); ok {
+			if clave, ok := e["key"].(string); ok {
+				nombres = append(nombres, clave)
+			}
+		}
+	}
+	// Los CTE recursivos y materializados se serializan además como nodos
+	// propios con el nombre en cte_name.
+	if nombre, ok := nodo["cte_name"].(string); ok {
+		nombres = append(nombres, nombre)
+	}
+	if len(nombres) == 0 {
+		return ctes
+	}
+	ambito := make(map[string]bool, len(ctes)+len(nombres))
+	for nombre := range ctes {
+		ambito[nombre] = true
+	}
+	for _, nombre := range nombres {
+		ambito[nombre] = true
+	}
+	return ambito
+}
+
+func recorrerArbol(v interface{}, visitar func(map[string]interface{}) error) error {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if err := visitar(x); err != nil {
+			return err
+		}
+		for _, hijo := range x {
+			if err := recorrerArbol(hijo, visitar); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, hijo := range x {
+			if err := recorrerArbol(hijo, visitar); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func valorConstante(v interface{}) string {
+	nodo, _ := v.(map[string]interface{})
+	if nodo["type"] != "VALUE_CONSTANT" {
+		return ""
+	}
+	valor, _ := nodo["value"].(map[string]interface{})
+	s, _ := valor["value"].(string)
+	return s
+}
@@ -0,0 +1,77 @@
+package connection
+
+import (
+	"testing"
+)
+
+func TestValidarConsulta(t *testing.T) {
+	db, err := abrirSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	archivo := "/datos/ventas.csv"
+	casos := []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT * FROM origen", true},
+		{"SELECT count(*) FROM '/datos/ventas.csv'", true},
+		{"SELECT * FROM read_csv('/datos/ventas.csv', header = true)", true},
+		{"WITH t AS (SELECT * FROM origen) SELECT * FROM t", true},
+		{"SELECT * FROM range(10)", true},
+		{"SELECT * FROM origen o JOIN (SELECT unnest([1, 2]) AS x) u ON true", true},
+		{"SELECT * FROM read_csv('/etc/passwd')", false},
+		{"SELECT * FROM '/etc/passwd'", false},
+		{"SELECT * FROM otra", false},
+		{"SELECT * FROM main.origen", false},
+		{"SELECT * FROM duckdb_settings()", false},
+		{"SELECT * FROM duckdb_views()", false},
+		{"SELECT * FROM glob('/*')", false},
+		{"SELECT getenv('HOME')", false},
+		{"SELECT * FROM origen; SELECT 1", false},
+		{"COPY origen TO '/tmp/x.csv'", false},
+		{"ATTACH '/tmp/x.db' AS x", false},
+		{"SET enable_external_access = true", false},
+		{"SELECT * FROM (SELECT * FROM read_parquet('/etc/shadow'))", false},
+		// Un CTE solo cubre las tablas de su propia consulta.
+		{"WITH t AS (SELECT * FROM origen), u AS (SELECT * FROM t) SELECT * FROM u", true},
+		{"WITH RECURSIVE t AS (SELECT 1 AS n UNION ALL SELECT n + 1 FROM t WHERE n < 3) SELECT * FROM t", true},
+		{"WITH u AS MATERIALIZED (SELECT * FROM origen) SELECT * FROM u", true},
+		{"SELECT * FROM (WITH sqlite_master AS (SELECT 1) SELECT * FROM sqlite_master) s", true},
+		{"WITH a AS (SELECT 1) SELECT * FROM a UNION ALL (WITH b AS (SELECT 2) SELECT * FROM b)", true},
+		{`SELECT * FROM (WITH "/etc/passwd" AS (SELECT 1) SELECT 1) a, '/etc/passwd' b`, false},
+		{`SELECT * FROM (WITH "/datos/ventas.csv" AS (SELECT 1) SELECT 1) a, '/datos/ventas.csv' b`, true},
+		{"SELECT * FROM (WITH duckdb_views AS (SELECT 1) SELECT 1) a, duckdb_views b", false},
+		{"SELECT * FROM (WITH sqlite_master AS (SELECT 1) SELECT 1) a, sqlite_master b", false},
+		{"SELECT * FROM b UNION ALL (WITH b AS (SELECT 2) SELECT * FROM b)", false},
+		{"SELECT (WITH s AS (SELECT 1) SELECT 1), * FROM s", false},
+		{"SELECT * FROM origen WHERE EXISTS (WITH s AS (SELECT 1) SELECT 1) AND 1 IN (SELECT * FROM s)", false},
+	}
+	for _, c := range casos {
+		err := validarConsulta(db, c.query, []string{vistaOrigen}, []string{archivo})
+		if (err == nil) != c.ok {
+			t.Errorf("validarConsulta(%q): error %v, se esperaba aceptada=%v", c.query, err, c.ok)
+		}
+	}
+}
+
+// La configuración del sandbox no se puede cambiar desde una consulta aunque
+// la validación dejara pasar la sentencia.
+func TestSandboxConfiguracionBloqueada(t *testing.T) {
+	db, err := abrirSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, sentencia := range []string{
+		"SET autoinstall_known_extensions = true",
+		"SET allow_community_extensions = true",
+		"SET lock_configuration = false",
+	} {
+		if _, err := db.Exec(sentencia); err == nil {
+			t.Errorf("%q no debería aceptarse en el sandbox", sentencia)
+		}
+	}
+}
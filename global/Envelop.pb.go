@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.7
+// 	protoc-gen-go v1.36.11
 // 	protoc        v3.12.4
 // source: Envelop.proto
 
@@ -30,6 +30,7 @@ type Envelop struct {
 	CreatedAt     int64                  `protobuf:"varint,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
 	Extra         string                 `protobuf:"bytes,6,opt,name=extra,proto3" json:"extra,omitempty"`
 	OriginPeerId  string                 `protobuf:"bytes,7,opt,name=origin_peer_id,json=originPeerId,proto3" json:"origin_peer_id,omitempty"`
+	Seq           uint64                 `protobuf:"varint,8,opt,name=seq,proto3" json:"seq,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -113,11 +114,18 @@ func (x *Envelop) GetOriginPeerId() string {
 	return ""
 }
 
+func (x *Envelop) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
 var File_Envelop_proto protoreflect.FileDescriptor
 
 const file_Envelop_proto_rawDesc = "" +
 	"\n" +
-	"\rEnvelop.proto\"\xc6\x01\n" +
+	"\rEnvelop.proto\"\xd8\x01\n" +
 	"\aEnvelop\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
 	"\aservice\x18\x02 \x01(\tR\aservice\x12\x18\n" +
@@ -126,7 +134,8 @@ const file_Envelop_proto_rawDesc = "" +
 	"\n" +
 	"created_at\x18\x05 \x01(\x03R\tcreatedAt\x12\x14\n" +
 	"\x05extra\x18\x06 \x01(\tR\x05extra\x12$\n" +
-	"\x0eorigin_peer_id\x18\a \x01(\tR\foriginPeerIdB\x10Z\x0e./proto;globalb\x06proto3"
+	"\x0eorigin_peer_id\x18\a \x01(\tR\foriginPeerId\x12\x10\n" +
+	"\x03seq\x18\b \x01(\x04R\x03seqB\x10Z\x0e./proto;globalb\x06proto3"
 
 var (
 	file_Envelop_proto_rawDescOnce sync.Once
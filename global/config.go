@@ -42,6 +42,17 @@ type LocalInterfaceType struct {
 	Server struct {
 		Port string `json:"port"`
 	} `json:"server"`
+	// Postgres, con puerto, publica los DATA_SOURCE remotos por el protocolo
+	// de PostgreSQL. Address es la IP donde escucha (127.0.0.1 si no se
+	// indica); sin Credentials el servidor no se inicia.
+	Postgres struct {
+		Port    string `json:"port"`
+		Address string `json:"address,omitempty"`
+	} `json:"postgres"`
+	// Credentials es el nombre del secreto con los usuarios de la interfaz
+	// local (usuario: contraseña), los mismos para la API HTTP y PostgreSQL.
+	// Sin credenciales la API HTTP no pide autenticación.
+	Credentials string `json:"credentials,omitempty"`
 }
 
 type RemoteResourceType struct {
@@ -54,19 +65,36 @@ type ConfigType struct {
 	Identity       IdentityType       `json:"identity"`
 	LocalInterface LocalInterfaceType `json:"localInterface"`
 	Networks       []NetworkType      `json:"networks"`
+	// SecretsPath es el archivo con las credenciales de los DATA_SOURCE, fuera
+	// de los recursos. Un valor "env:VAR" se toma de la variable de entorno.
+	SecretsPath string                       `json:"secrets,omitempty"`
+	Secrets     map[string]map[string]string `json:"-"`
 }
 
 type NetworkType struct {
-	Port                string        `json:"port"`
-	FS                  string        `json:"filesystem"`
-	Name                string        `json:"name"`
-	Pivots              []string      `json:"pivots"`
-	NetworkKey          string        `json:"network_key"`
-	MyAddress           []string      `json:"myAddress"`
-	Entities            []KVType      `json:"entities"`
-	Topics              []TopicType   `json:"topics"`
-	RemoteResourcesPath string        `json:"remote_resources"`
-	RemoteResources     ResourcesType `json:"-"`
-	ResourcesPath       string        `json:"resources"`
-	Resources           ResourcesType `json:"-"`
+	Port                string         `json:"port"`
+	FS                  string         `json:"filesystem"`
+	Name                string         `json:"name"`
+	Pivots              []string       `json:"pivots"`
+	NetworkKey          string         `json:"network_key"`
+	MyAddress           []string       `json:"myAddress"`
+	Entities            []KVType       `json:"entities"`
+	Topics              []TopicType    `json:"topics"`
+	RemoteResourcesPath string         `json:"remote_resources"`
+	RemoteResources     ResourcesType  `json:"-"`
+	ResourcesPath       string         `json:"resources"`
+	Resources           ResourcesType  `json:"-"`
+	FSCache             *FileCacheType `json:"filesystem_cache,omitempty"`
+}
+
+// FileCacheType guarda en Dir los archivos remotos leídos desde el punto de
+// montaje, hasta MaxBytes. Un archivo entra en la caché cuando se leyeron
+// todos sus bloques; con Prefetch se descarga completo al abrirlo. Offline
+// implica Prefetch y sigue leyendo los archivos en caché aunque el
+// proveedor no responda.
+type FileCacheType struct {
+	Dir      string `json:"dir"`
+	MaxBytes int64  `json:"max_bytes"`
+	Prefetch bool   `json:"prefetch,omitempty"`
+	Offline  bool   `json:"offline,omitempty"`
 }
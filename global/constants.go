@@ -24,10 +24,29 @@ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 SOFTWARE.
 */
 const (
-	ProtocolJoin           = "/join/1.0.0"
-	ProtocolAuth           = "/auth/1.0.0"
-	ProtocolAPIProxy       = "/api-proxy/1.0.0"
-	ProtocolFileSystem     = "/file-system/1.0.0"
-	ProtocolFileSystemStat = "/file-system/stat/1.0.0"
-	ProtocolQuery          = "/query/1.0.0"
+	ProtocolJoin            = "/join/1.0.0"
+	ProtocolAuth            = "/auth/1.0.0"
+	ProtocolAPIProxy        = "/api-proxy/1.0.0"
+	ProtocolAPICatalog      = "/api-proxy/catalog/1.0.0"
+	ProtocolFileSystem      = "/file-system/1.0.0"
+	ProtocolFileSystemStat  = "/file-system/stat/1.0.0"
+	ProtocolFileSystemRange = "/file-system/range/1.0.0"
+	ProtocolFileSystemStatX = "/file-system/stat/2.0.0"
+	ProtocolFileSystemList  = "/file-system/list/1.0.0"
+	ProtocolQuery           = "/query/1.0.0"
+	ProtocolQuerySchema     = "/query/schema/1.0.0"
+	ProtocolQuerySubscribe  = "/query/subscribe/1.0.0"
+	ProtocolTCPTunnel       = "/tcp-tunnel/1.0.0"
+	ProtocolGRPCProxy       = "/grpc-proxy/1.0.0"
+
+	ResourceTypeGRPC = "grpc"
+
+	// Tipos de DATA_SOURCE. Sin tipo, ResourcePath es un archivo o un glob
+	// local.
+	ResourceTypeFile     = "file"
+	ResourceTypeSQLite   = "sqlite"
+	ResourceTypePostgres = "postgres"
+	ResourceTypeMySQL    = "mysql"
+	ResourceTypeS3       = "s3"
+	ResourceTypeHTTP     = "http"
 )
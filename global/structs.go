@@ -29,11 +29,127 @@ type ResourcesType struct {
 	API        []ResourceType `json:"API"`
 	FILE       []ResourceType `json:"FILE"`
 	DATASOURCE []ResourceType `json:"DATA_SOURCE"`
+	TCP        []ResourceType `json:"TCP"`
 }
 
 type ResourceType struct {
 	Name         string `json:"name"`
 	ResourcePath string `json:"resource_path"`
+	// Type distingue variantes de un mismo recurso, por ejemplo "grpc" en API
+	// o el motor de un DATA_SOURCE.
+	Type string `json:"type,omitempty"`
+	// Table es la tabla publicada por un DATA_SOURCE sqlite, postgres o mysql.
+	// Secret nombra las credenciales en el archivo de secretos del nodo.
+	Table  string `json:"table,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	// Address es el host:port local. En los recursos propios es el servicio
+	// que se expone; en los remotos es donde se escucha localmente.
+	Address string `json:"address,omitempty"`
+	// Proxy limita las llamadas salientes hacia el servicio publicado.
+	Proxy *ProxyLimitsType `json:"proxy,omitempty"`
+	// Quota limita el uso por entidad; Entities permite cuotas particulares.
+	Quota *QuotaType `json:"quota,omitempty"`
+	// OpenAPI es la ruta o URL del documento que describe el API publicado.
+	OpenAPI string `json:"openapi,omitempty"`
+	// Include y Exclude filtran, con patrones como "**/*.csv", los archivos
+	// de un FILE que publica un directorio.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Cache activa, en remote_resources, la caché local de respuestas GET y,
+	// en un DATA_SOURCE propio, la caché de resultados de consultas.
+	Cache *CacheType `json:"cache,omitempty"`
+	// Description y Columns documentan un DATA_SOURCE en su esquema.
+	Description string            `json:"description,omitempty"`
+	Columns     map[string]string `json:"columns,omitempty"`
+	// Templates son consultas con nombre y parámetros tipados que el
+	// consumidor puede invocar. DisableFreeSQL deja solo las plantillas.
+	Templates      []QueryTemplateType `json:"templates,omitempty"`
+	DisableFreeSQL bool                `json:"disable_free_sql,omitempty"`
+	// Policies restringe lo que cada entidad ve de un DATA_SOURCE. Entity "*"
+	// aplica a las entidades sin política propia.
+	Policies []DataPolicyType `json:"policies,omitempty"`
+	// Limits acota cada consulta a un DATA_SOURCE; sin límites la consulta
+	// corre hasta terminar.
+	Limits *QueryLimitsType `json:"limits,omitempty"`
+	// CursorTTLSeconds es cuánto se guarda sin uso el resultado de una
+	// consulta en modo cursor.
+	CursorTTLSeconds int `json:"cursor_ttl_seconds,omitempty"`
+	// Snapshots materializa consultas sobre el DATA_SOURCE cada cierto
+	// tiempo y las publica como fuentes de datos propias.
+	Snapshots []SnapshotType `json:"snapshots,omitempty"`
+}
+
+type QueryTemplateType struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Query       string              `json:"query"`
+	Params      []TemplateParamType `json:"params,omitempty"`
+}
+
+// TemplateParamType se enlaza en la plantilla como $Name. Los tipos son
+// string, integer, number, boolean, date y timestamp; las fechas se enlazan
+// como texto ISO, así que la plantilla debe convertirlas con CAST.
+type TemplateParamType struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+type DataPolicyType struct {
+	Entity string   `json:"entity"`
+	Hide   []string `json:"hide,omitempty"`
+	Hash   []string `json:"hash,omitempty"`
+	Redact []string `json:"redact,omitempty"`
+	Where  []string `json:"where,omitempty"`
+}
+
+// QueryLimitsType usa MemoryLimit con la notación de DuckDB, por ejemplo
+// "512MB".
+type QueryLimitsType struct {
+	TimeoutSeconds int    `json:"timeout_seconds"`
+	MaxRows        int64  `json:"max_rows"`
+	MaxBytes       int64  `json:"max_bytes"`
+	MemoryLimit    string `json:"memory_limit,omitempty"`
+	Threads        int    `json:"threads,omitempty"`
+}
+
+// CacheType se aplica a los resultados de un DATA_SOURCE con TTLSeconds (0
+// es sin vencimiento mientras no cambie la fuente) y Validate, que es "mtime"
+// o "hash" según cómo se detectan los cambios en los archivos.
+type CacheType struct {
+	MaxEntries int    `json:"max_entries"`
+	MaxBytes   int64  `json:"max_bytes"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	Validate   string `json:"validate,omitempty"`
+}
+
+// SnapshotType se publica como el DATA_SOURCE Name. Query usa {{ORIGIN}}
+// como las consultas de los consumidores.
+type SnapshotType struct {
+	Name            string           `json:"name"`
+	Description     string           `json:"description,omitempty"`
+	Query           string           `json:"query"`
+	IntervalSeconds int              `json:"interval_seconds"`
+	ResourcePath    string           `json:"resource_path,omitempty"`
+	Policies        []DataPolicyType `json:"policies,omitempty"`
+}
+
+// QuotaType limita las peticiones por segundo y, por día, las peticiones y
+// los bytes enviados al consumidor (DailyBytes).
+type QuotaType struct {
+	RequestsPerSecond float64              `json:"requests_per_second"`
+	Burst             int                  `json:"burst"`
+	DailyRequests     int                  `json:"daily_requests"`
+	DailyBytes        int64                `json:"daily_bytes,omitempty"`
+	Entities          map[string]QuotaType `json:"entities,omitempty"`
+}
+
+type ProxyLimitsType struct {
+	TimeoutSeconds         int `json:"timeout_seconds"`
+	MaxConcurrent          int `json:"max_concurrent"`
+	BreakerFailures        int `json:"breaker_failures"`
+	BreakerCooldownSeconds int `json:"breaker_cooldown_seconds"`
 }
 
 type InvitacionType struct {
@@ -0,0 +1,81 @@
+package localinterface
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	configuration "Veredarii/configuration"
+	"Veredarii/connection"
+	"Veredarii/global"
+)
+
+// startGRPC levanta un endpoint HTTP/2 sin TLS (h2c) por cada servicio gRPC
+// remoto, en la dirección indicada en remote_resources.
+func startGRPC() {
+	for _, network := range configuration.CM.GetConfig().Networks {
+		for _, service := range network.RemoteResources.API {
+			if service.Type != global.ResourceTypeGRPC {
+				continue
+			}
+			if service.Address == "" {
+				log.Warn("Servicio gRPC remoto sin dirección local: ", service.Name)
+				continue
+			}
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := connection.NM.Networks[network.Name]
+				targetID := n.BuscarServicio(context.Background(), service.Name)
+				if targetID == "" {
+					log.Error("Servicio no encontrado")
+					w.Header().Set("Content-Type", "application/grpc")
+					w.Header().Set("Grpc-Status", "14")
+					w.Header().Set("Grpc-Message", "servicio no encontrado")
+					return
+				}
+				if err := n.ConversarGRPC(targetID, service.Name, w, r); err != nil {
+					log.Error("Error en la llamada gRPC: ", err)
+				}
+			})
+
+			var protocols http.Protocols
+			protocols.SetUnencryptedHTTP2(true)
+			server := &http.Server{
+				Addr:      service.Address,
+				Handler:   handler,
+				Protocols: &protocols,
+			}
+			go func() {
+				fmt.Printf("gRPC '%s' escuchando en %s\n", service.Name, service.Address)
+				if err := server.ListenAndServe(); err != nil {
+					log.Printf("Error server gRPC: %v", err)
+				}
+			}()
+		}
+	}
+}
@@ -27,21 +27,27 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	configuration "Veredarii/configuration"
 	"Veredarii/connection"
+	"Veredarii/global"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -57,6 +63,8 @@ func Start() {
 		Router: chi.NewRouter(),
 	}
 	_ = LocalServer.setupRouter()
+	startGRPC()
+	startPostgres()
 
 	go func() {
 
@@ -76,10 +84,68 @@ func Start() {
 func (n *LocalServer) setupRouter() (iplocal string) {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	credenciales, err := credencialesLocales()
+	if err != nil {
+		// Si el secreto no se puede leer no se acepta a nadie.
+		log.Error(err)
+		credenciales = map[string]string{}
+	}
+	if credenciales != nil {
+		r.Use(autenticacionBasica(credenciales))
+	}
+
+	// Consultas que combinan fuentes de varias redes con remote('red', 'ds').
+	r.Post("/_sql", func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if query.Query == "" {
+			http.Error(w, "Query is required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := connection.ConsultaDistribuida(r.Context(), query.Query)
+		switch {
+		case errors.Is(err, connection.ErrFuenteRemota):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(res)
+			return
+		case err != nil:
+			responderErrorQuery(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
 
 	for _, network := range configuration.CM.GetConfig().Networks {
 		for _, service := range network.RemoteResources.API {
-			r.Get("/"+network.Name+"/"+service.Name, func(w http.ResponseWriter, r *http.Request) {
+			if service.Type == global.ResourceTypeGRPC {
+				continue
+			}
+			// El catálogo apunta los clientes a /{red}/{servicio}; la ruta que
+			// sigue y el método se reenvían tal cual al servicio.
+			gateway := func(w http.ResponseWriter, r *http.Request) {
+				r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+network.Name+"/"+service.Name), "/")
+				r.URL.RawPath = ""
+				r.RequestURI = r.URL.RequestURI()
+				if service.Cache != nil && r.Method == http.MethodGet && r.Header.Get("Upgrade") == "" {
+					err := connection.NM.Networks[network.Name].ConversarCacheado(service, r, w)
+					if errors.Is(err, connection.ErrServicioNoEncontrado) {
+						log.Error("Servicio no encontrado")
+						w.WriteHeader(http.StatusNotFound)
+					} else if err != nil {
+						log.Error("Error en la llamada remota: ", err)
+						w.WriteHeader(http.StatusBadGateway)
+					}
+					return
+				}
 
 				requestDump, err := httputil.DumpRequest(r, true)
 				if err != nil {
@@ -93,12 +159,159 @@ func (n *LocalServer) setupRouter() (iplocal string) {
 					w.WriteHeader(http.StatusNotFound)
 					return
 				}
-				respuesta := connection.NM.Networks[network.Name].Conversar(targetID, service.Name, requestDump)
-				w.Write(respuesta)
-			})
+				err = connection.NM.Networks[network.Name].ConversarHTTP(targetID, service.Name, requestDump, w)
+				if err != nil {
+					log.Error("Error en la llamada remota: ", err)
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}
+			r.HandleFunc("/"+network.Name+"/"+service.Name, gateway)
+			r.HandleFunc("/"+network.Name+"/"+service.Name+"/*", gateway)
 		}
 
+		r.Get("/"+network.Name+"/_status", func(w http.ResponseWriter, r *http.Request) {
+			estado := map[string]interface{}{
+				"network":  network.Name,
+				"breakers": connection.NM.Networks[network.Name].EstadoBreakers(),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(estado)
+		})
+
+		r.Get("/"+network.Name+"/_catalog", func(w http.ResponseWriter, r *http.Request) {
+			catalogo := map[string]interface{}{
+				"network": network.Name,
+				"apis":    connection.NM.Networks[network.Name].Catalogo(gatewayURL()),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(catalogo)
+		})
+
+		r.Get("/"+network.Name+"/_catalog/{service}", func(w http.ResponseWriter, r *http.Request) {
+			service := chi.URLParam(r, "service")
+			if !apiRemota(network.RemoteResources, service) {
+				http.Error(w, "servicio no encontrado", http.StatusNotFound)
+				return
+			}
+			ruta := gatewayURL() + "/" + network.Name + "/" + service
+			entrada := connection.NM.Networks[network.Name].CatalogoServicio(service, ruta)
+			if entrada.Error != "" {
+				http.Error(w, entrada.Error, http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entrada.Spec)
+		})
+
 		for _, datasource := range network.RemoteResources.DATASOURCE {
+			r.Post("/"+network.Name+"/ds/"+datasource.Name+"/federated", func(w http.ResponseWriter, r *http.Request) {
+				var query struct {
+					connection.QueryType
+					Merge string `json:"merge"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+					http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer r.Body.Close()
+				if query.Query == "" && query.Template == "" {
+					http.Error(w, "Query or template is required", http.StatusBadRequest)
+					return
+				}
+
+				res, err := connection.NM.Networks[network.Name].QueryFederada(r.Context(), datasource.Name, query.QueryType, query.Merge)
+				switch {
+				case errors.Is(err, connection.ErrServicioNoEncontrado):
+					log.Error("Datasource no encontrado")
+					w.WriteHeader(http.StatusNotFound)
+					return
+				case errors.Is(err, connection.ErrSinProveedores):
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadGateway)
+					json.NewEncoder(w).Encode(res)
+					return
+				case err != nil:
+					responderErrorQuery(w, err)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(res)
+			})
+
+			// Los cambios de la suscripción se entregan como eventos SSE, con
+			// las filas en JSON.
+			r.Post("/"+network.Name+"/ds/"+datasource.Name+"/subscribe", func(w http.ResponseWriter, r *http.Request) {
+				var sub connection.SubscriptionType
+				if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+					http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer r.Body.Close()
+				if sub.Query == "" && sub.Template == "" {
+					http.Error(w, "Query or template is required", http.StatusBadRequest)
+					return
+				}
+				sub.Format = "json"
+
+				targetID := connection.NM.Networks[network.Name].BuscarServicio(context.Background(), datasource.Name)
+				if targetID == "" {
+					log.Error("Datasource no encontrado")
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				cw := &escritorContado{ResponseWriter: w}
+				err := connection.NM.Networks[network.Name].Suscribir(r.Context(), targetID, sub, datasource.Name, func(c connection.QueryChange, filas []byte) error {
+					if cw.n == 0 {
+						w.Header().Set("Content-Type", "text/event-stream")
+						w.Header().Set("Cache-Control", "no-cache")
+					}
+					evento, _ := json.Marshal(struct {
+						connection.QueryChange
+						Rows json.RawMessage `json:"rows"`
+					}{c, filas})
+					if _, err := fmt.Fprintf(cw, "event: change\nid: %d\ndata: %s\n\n", c.Version, evento); err != nil {
+						return err
+					}
+					cw.Flush()
+					return nil
+				})
+				if err == nil || r.Context().Err() != nil {
+					return
+				}
+				if cw.n == 0 {
+					responderErrorQuery(w, err)
+					return
+				}
+				log.Error("Suscripción terminada: ", err)
+				detalle, _ := json.Marshal(map[string]string{"message": err.Error()})
+				var qe *connection.QueryError
+				if errors.As(err, &qe) {
+					detalle, _ = json.Marshal(qe)
+				}
+				fmt.Fprintf(cw, "event: error\ndata: %s\n\n", detalle)
+			})
+
+			r.Get("/"+network.Name+"/ds/"+datasource.Name+"/schema", func(w http.ResponseWriter, r *http.Request) {
+				targetID := connection.NM.Networks[network.Name].BuscarServicio(context.Background(), datasource.Name)
+				if targetID == "" {
+					log.Error("Datasource no encontrado")
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				esquemas, err := connection.NM.Networks[network.Name].Esquema(targetID, datasource.Name)
+				if err != nil {
+					responderErrorQuery(w, err)
+					return
+				}
+				if len(esquemas) == 0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(esquemas[0])
+			})
+
 			r.Post("/"+network.Name+"/ds/"+datasource.Name, func(w http.ResponseWriter, r *http.Request) {
 				var query connection.QueryType
 				if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
@@ -108,8 +321,16 @@ func (n *LocalServer) setupRouter() (iplocal string) {
 				defer r.Body.Close()
 
 				// Validaciones opcionales
-				if query.Query == "" {
-					http.Error(w, "Query is required", http.StatusBadRequest)
+				if query.Query == "" && query.Template == "" {
+					http.Error(w, "Query or template is required", http.StatusBadRequest)
+					return
+				}
+				if query.Format == "" {
+					query.Format = "json"
+				}
+				contentType, ok := connection.FormatosQuery[query.Format]
+				if !ok {
+					http.Error(w, "Unsupported format: "+query.Format, http.StatusBadRequest)
 					return
 				}
 
@@ -119,8 +340,73 @@ func (n *LocalServer) setupRouter() (iplocal string) {
 					w.WriteHeader(http.StatusNotFound)
 					return
 				}
+				consultar := connection.NM.Networks[network.Name].Query
+				if query.Cursor || query.CursorID != "" || query.PageToken != "" {
+					consultar = connection.NM.Networks[network.Name].QueryCursor
+				}
+
+				if query.FileName != "" {
+					f, err := os.Create(query.FileName)
+					if err != nil {
+						http.Error(w, "Error creando archivo: "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+					defer f.Close()
+					cierre, err := consultar(r.Context(), targetID, query, datasource.Name, f)
+					if err != nil {
+						responderErrorQuery(w, err)
+						return
+					}
+					info, _ := f.Stat()
+					resumen := map[string]interface{}{
+						"file":        query.FileName,
+						"bytes":       info.Size(),
+						"query_id":    cierre.QueryID,
+						"rows":        cierre.Rows,
+						"duration_ms": cierre.DurationMs,
+					}
+					if cierre.CursorID != "" {
+						resumen["cursor_id"] = cierre.CursorID
+					}
+					if cierre.NextToken != "" {
+						resumen["next_offset"] = cierre.NextOffset
+						resumen["next_token"] = cierre.NextToken
+					}
+					if cierre.Cached {
+						resumen["cached"] = true
+					}
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(resumen)
+					return
+				}
 
-				connection.NM.Networks[network.Name].Query(targetID, query, datasource.Name)
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Trailer", "X-Query-Id, X-Query-Rows, X-Query-Duration-Ms, X-Query-Cursor, X-Query-Next-Offset, X-Query-Next-Token, X-Query-Cache")
+				cw := &escritorContado{ResponseWriter: w}
+				cierre, err := consultar(r.Context(), targetID, query, datasource.Name, cw)
+				if err != nil {
+					if cw.n > 0 {
+						// La respuesta ya está en curso; solo queda cortarla.
+						log.Error("Error en la consulta remota: ", err)
+						panic(http.ErrAbortHandler)
+					}
+					w.Header().Del("Trailer")
+					responderErrorQuery(w, err)
+					return
+				}
+				w.Header().Set("X-Query-Id", cierre.QueryID)
+				w.Header().Set("X-Query-Rows", strconv.FormatInt(cierre.Rows, 10))
+				w.Header().Set("X-Query-Duration-Ms", strconv.FormatInt(cierre.DurationMs, 10))
+				if cierre.CursorID != "" {
+					w.Header().Set("X-Query-Cursor", cierre.CursorID)
+				}
+				if cierre.NextToken != "" {
+					w.Header().Set("X-Query-Next-Offset", strconv.FormatInt(cierre.NextOffset, 10))
+					w.Header().Set("X-Query-Next-Token", cierre.NextToken)
+				}
+				if cierre.Cached {
+					w.Header().Set("X-Query-Cache", "HIT")
+				}
 			})
 		}
 
@@ -137,6 +423,103 @@ func (n *LocalServer) setupRouter() (iplocal string) {
 	return iplocal
 }
 
+// escritorContado registra si ya se envió parte de la respuesta, para saber si
+// todavía se puede responder con un código de error.
+type escritorContado struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (e *escritorContado) Write(p []byte) (int, error) {
+	n, err := e.ResponseWriter.Write(p)
+	e.n += int64(n)
+	return n, err
+}
+
+func (e *escritorContado) Flush() {
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func responderErrorQuery(w http.ResponseWriter, err error) {
+	var qe *connection.QueryError
+	if !errors.As(err, &qe) {
+		log.Error("Error en la consulta remota: ", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	status := http.StatusBadGateway
+	switch qe.Code {
+	case "QUOTA_EXCEEDED":
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(qe.RetryAfter))
+	case "QUERY_FAILED", "UNSUPPORTED_FORMAT", "INVALID_PARAMS":
+		status = http.StatusBadRequest
+	case "QUERY_REJECTED":
+		status = http.StatusForbidden
+	case "NOT_FOUND":
+		status = http.StatusNotFound
+	case "TIMEOUT":
+		status = http.StatusGatewayTimeout
+	case "ROW_LIMIT_EXCEEDED", "BYTE_LIMIT_EXCEEDED", "MEMORY_LIMIT_EXCEEDED":
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(qe)
+}
+
+// credencialesLocales devuelve los usuarios de la interfaz local, o nil si no
+// se configuraron. Los comparten la API HTTP y el servidor PostgreSQL.
+func credencialesLocales() (map[string]string, error) {
+	nombre := configuration.CM.GetConfig().LocalInterface.Credentials
+	if nombre == "" {
+		return nil, nil
+	}
+	credenciales, err := connection.LeerSecreto(nombre)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo las credenciales locales: %w", err)
+	}
+	if len(credenciales) == 0 {
+		return nil, fmt.Errorf("el secreto %s no tiene usuarios", nombre)
+	}
+	return credenciales, nil
+}
+
+func autenticacionBasica(credenciales map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			usuario, clave, ok := r.BasicAuth()
+			esperada, existe := credenciales[strings.ToLower(usuario)]
+			if !ok || !existe || subtle.ConstantTimeCompare([]byte(clave), []byte(esperada)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="veredarii"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gatewayURL es la URL base de la interfaz local según el puerto
+// configurado. No se toma del Host de la petición: lo elige el cliente y
+// terminaría en los servidores de los documentos del catálogo.
+func gatewayURL() string {
+	return "http://" + net.JoinHostPort("localhost", configuration.CM.GetConfig().LocalInterface.Server.Port)
+}
+
+// apiRemota indica si service es un API HTTP remoto de la red, los mismos
+// que lista el catálogo.
+func apiRemota(remotos global.ResourcesType, service string) bool {
+	for _, api := range remotos.API {
+		if api.Name == service && api.Type != global.ResourceTypeGRPC {
+			return true
+		}
+	}
+	return false
+}
+
 func generateSelfSignedCert(iplocal string) (tls.Certificate, error) {
 	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
 	template := x509.Certificate{
@@ -0,0 +1,20 @@
+package localinterface
+
+import (
+	"testing"
+
+	"Veredarii/global"
+)
+
+func TestApiRemota(t *testing.T) {
+	remotos := global.ResourcesType{API: []global.ResourceType{
+		{Name: "ventas"},
+		{Name: "pagos", Type: global.ResourceTypeGRPC},
+	}}
+	casos := map[string]bool{"ventas": true, "pagos": false, "otro": false, "": false}
+	for servicio, esperado := range casos {
+		if obtenido := apiRemota(remotos, servicio); obtenido != esperado {
+			t.Errorf("apiRemota(%q) = %v, se esperaba %v", servicio, obtenido, esperado)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package localinterface
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Autenticación SCRAM-SHA-256 (RFC 5802 y 7677), como la de PostgreSQL. Las
+// contraseñas del secreto se derivan al iniciar y la sesión solo conoce las
+// claves resultantes. No se admite channel binding.
+const (
+	pgAuthSASL          = 10
+	pgAuthSASLContinuar = 11
+	pgAuthSASLFinal     = 12
+	mecanismoSCRAM      = "SCRAM-SHA-256"
+	iteracionesSCRAM    = 4096
+)
+
+type verificadorSCRAM struct {
+	sal         []byte
+	iteraciones int
+	storedKey   []byte
+	serverKey   []byte
+}
+
+func nuevoVerificadorSCRAM(clave string) (verificadorSCRAM, error) {
+	sal := make([]byte, 16)
+	rand.Read(sal)
+	salada, err := pbkdf2.Key(sha256.New, clave, sal, iteracionesSCRAM, sha256.Size)
+	if err != nil {
+		return verificadorSCRAM{}, err
+	}
+	storedKey := sha256.Sum256(hmacSHA256(salada, "Client Key"))
+	return verificadorSCRAM{
+		sal:         sal,
+		iteraciones: iteracionesSCRAM,
+		storedKey:   storedKey[:],
+		serverKey:   hmacSHA256(salada, "Server Key"),
+	}, nil
+}
+
+// verificadoresSCRAM deriva las claves de cada usuario del secreto.
+func verificadoresSCRAM(credenciales map[string]string) (map[string]verificadorSCRAM, error) {
+	verificadores := make(map[string]verificadorSCRAM, len(credenciales))
+	for usuario, clave := range credenciales {
+		v, err := nuevoVerificadorSCRAM(clave)
+		if err != nil {
+			return nil, err
+		}
+		verificadores[strings.ToLower(usuario)] = v
+	}
+	return verificadores, nil
+}
+
+// secretoSimulado fija durante la vida del proceso la sal que recibe cada
+// usuario desconocido, como hace PostgreSQL: dos intentos con el mismo nombre
+// ven la misma sal, igual que con un usuario real.
+var secretoSimulado = sync.OnceValue(func() []byte {
+	secreto := make([]byte, 32)
+	rand.Read(secreto)
+	return secreto
+})
+
+// verificadorSimulado se deriva una sola vez y lo comparten todos los usuarios
+// desconocidos, para que un intento sin usuario válido no cueste un PBKDF2.
+var verificadorSimulado = sync.OnceValues(func() (verificadorSCRAM, error) {
+	aleatoria := make([]byte, 32)
+	rand.Read(aleatoria)
+	return nuevoVerificadorSCRAM(string(aleatoria))
+})
+
+func verificadorDesconocido(usuario string) (verificadorSCRAM, error) {
+	v, err := verificadorSimulado()
+	if err != nil {
+		return verificadorSCRAM{}, err
+	}
+	v.sal = hmacSHA256(secretoSimulado(), strings.ToLower(usuario))[:len(v.sal)]
+	return v, nil
+}
+
+func hmacSHA256(clave []byte, mensaje string) []byte {
+	mac := hmac.New(sha256.New, clave)
+	mac.Write([]byte(mensaje))
+	return mac.Sum(nil)
+}
+
+// autenticarSCRAM hace el intercambio con el cliente. Para un usuario
+// desconocido se usa el verificador simulado, así el rechazo llega en el
+// mismo punto que con una contraseña equivocada.
+func (s *sesionPG) autenticarSCRAM(verificadores map[string]verificadorSCRAM) error {
+	v, ok := verificadores[strings.ToLower(s.usuario)]
+	if !ok {
+		var err error
+		if v, err = verificadorDesconocido(s.usuario); err != nil {
+			return err
+		}
+	}
+
+	if err := s.enviar('R', append(entero32(pgAuthSASL), mecanismoSCRAM+"\x00\x00"...)); err != nil {
+		return err
+	}
+	s.w.Flush()
+	tipo, cuerpo, err := s.leerMensaje()
+	if err != nil {
+		return err
+	}
+	l := &lectorPG{b: cuerpo}
+	mecanismo := l.cadena()
+	primero := string(l.bytes(int(l.entero32())))
+	if tipo != 'p' || l.err != nil || mecanismo != mecanismoSCRAM {
+		return s.rechazar("mecanismo de autenticación no soportado")
+	}
+	cabecera, primeroBase, nonceCliente, err := leerPrimeroSCRAM(primero)
+	if err != nil {
+		return s.rechazar(err.Error())
+	}
+
+	nonceServidor := make([]byte, 18)
+	rand.Read(nonceServidor)
+	nonce := nonceCliente + base64.StdEncoding.EncodeToString(nonceServidor)
+	primeroServidor := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(v.sal), v.iteraciones)
+	if err := s.enviar('R', append(entero32(pgAuthSASLContinuar), primeroServidor...)); err != nil {
+		return err
+	}
+	s.w.Flush()
+
+	tipo, cuerpo, err = s.leerMensaje()
+	if err != nil {
+		return err
+	}
+	if tipo != 'p' {
+		return s.rechazar("se esperaba la respuesta SASL")
+	}
+	final := string(cuerpo)
+	i := strings.LastIndex(final, ",p=")
+	if i < 0 {
+		return s.rechazar("mensaje SCRAM final inválido")
+	}
+	finalSinPrueba := final[:i]
+	prueba, err := base64.StdEncoding.DecodeString(final[i+len(",p="):])
+	if err != nil || len(prueba) != sha256.Size {
+		return s.rechazar("prueba SCRAM inválida")
+	}
+	atributos := atributosSCRAM(finalSinPrueba)
+	if atributos["c"] != base64.StdEncoding.EncodeToString([]byte(cabecera)) || atributos["r"] != nonce {
+		return s.rechazar("mensaje SCRAM final inválido")
+	}
+
+	mensaje := primeroBase + "," + primeroServidor + "," + finalSinPrueba
+	firma := hmacSHA256(v.storedKey, mensaje)
+	clienteKey := make([]byte, sha256.Size)
+	for i := range clienteKey {
+		clienteKey[i] = prueba[i] ^ firma[i]
+	}
+	calculada := sha256.Sum256(clienteKey)
+	if subtle.ConstantTimeCompare(calculada[:], v.storedKey) != 1 || !ok {
+		return s.rechazar("autenticación fallida para el usuario " + s.usuario)
+	}
+	verificacion := "v=" + base64.StdEncoding.EncodeToString(hmacSHA256(v.serverKey, mensaje))
+	return s.enviar('R', append(entero32(pgAuthSASLFinal), verificacion...))
+}
+
+func (s *sesionPG) rechazar(motivo string) error {
+	s.fallar("28P01", motivo)
+	s.w.Flush()
+	return fmt.Errorf("%s: %s", s.usuario, motivo)
+}
+
+// leerPrimeroSCRAM separa el primer mensaje del cliente en la cabecera GS2,
+// el resto sin ella y el nonce. El usuario viaja vacío: vale el del inicio.
+func leerPrimeroSCRAM(mensaje string) (string, string, string, error) {
+	partes := strings.SplitN(mensaje, ",", 3)
+	if len(partes) != 3 {
+		return "", "", "", errors.New("mensaje SCRAM inicial inválido")
+	}
+	switch {
+	case partes[0] == "n" || partes[0] == "y":
+	case strings.HasPrefix(partes[0], "p="):
+		return "", "", "", errors.New("channel binding no soportado")
+	default:
+		return "", "", "", errors.New("mensaje SCRAM inicial inválido")
+	}
+	if partes[1] != "" {
+		return "", "", "", errors.New("authzid no soportado")
+	}
+	nonce := atributosSCRAM(partes[2])["r"]
+	if nonce == "" {
+		return "", "", "", errors.New("mensaje SCRAM inicial sin nonce")
+	}
+	return partes[0] + "," + partes[1] + ",", partes[2], nonce, nil
+}
+
+func atributosSCRAM(mensaje string) map[string]string {
+	atributos := map[string]string{}
+	for _, campo := range strings.Split(mensaje, ",") {
+		if len(campo) >= 2 && campo[1] == '=' {
+			atributos[campo[:1]] = campo[2:]
+		}
+	}
+	return atributos
+}
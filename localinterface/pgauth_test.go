@@ -0,0 +1,149 @@
+package localinterface
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// clientePG es el lado del cliente de una conexión de prueba.
+type clientePG struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func (c *clientePG) enviar(tipo byte, cuerpo []byte) {
+	mensaje := binary.BigEndian.AppendUint32([]byte{tipo}, uint32(len(cuerpo)+4))
+	if _, err := c.c.Write(append(mensaje, cuerpo...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *clientePG) leer() (byte, []byte) {
+	tipo, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var largo uint32
+	binary.Read(c.r, binary.BigEndian, &largo)
+	cuerpo := make([]byte, largo-4)
+	if _, err := io.ReadFull(c.r, cuerpo); err != nil {
+		c.t.Fatal(err)
+	}
+	return tipo, cuerpo
+}
+
+// autenticar hace el intercambio SCRAM-SHA-256 como un cliente y devuelve si
+// el servidor aceptó la contraseña y si su firma final es válida.
+func (c *clientePG) autenticar(clave string) (bool, bool) {
+	tipo, cuerpo := c.leer()
+	if tipo != 'R' || binary.BigEndian.Uint32(cuerpo) != pgAuthSASL {
+		c.t.Fatalf("se esperaba AuthenticationSASL, llegó %q", tipo)
+	}
+	primero := "n,,n=,r=nonce-del-cliente"
+	inicial := append([]byte(mecanismoSCRAM+"\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(primero)))...)
+	c.enviar('p', append(inicial, primero...))
+
+	tipo, cuerpo = c.leer()
+	if tipo != 'R' || binary.BigEndian.Uint32(cuerpo) != pgAuthSASLContinuar {
+		c.t.Fatalf("se esperaba AuthenticationSASLContinue, llegó %q", tipo)
+	}
+	primeroServidor := string(cuerpo[4:])
+	atributos := atributosSCRAM(primeroServidor)
+	sal, _ := base64.StdEncoding.DecodeString(atributos["s"])
+	salada, _ := pbkdf2.Key(sha256.New, clave, sal, iteracionesSCRAM, sha256.Size)
+	clienteKey := hmacSHA256(salada, "Client Key")
+	storedKey := sha256.Sum256(clienteKey)
+	final := "c=biws,r=" + atributos["r"]
+	mensaje := "n=,r=nonce-del-cliente," + primeroServidor + "," + final
+	firma := hmacSHA256(storedKey[:], mensaje)
+	for i := range clienteKey {
+		clienteKey[i] ^= firma[i]
+	}
+	c.enviar('p', []byte(final+",p="+base64.StdEncoding.EncodeToString(clienteKey)))
+
+	tipo, cuerpo = c.leer()
+	if tipo != 'R' {
+		return false, false
+	}
+	esperada := "v=" + base64.StdEncoding.EncodeToString(hmacSHA256(hmacSHA256(salada, "Server Key"), mensaje))
+	return true, binary.BigEndian.Uint32(cuerpo) == pgAuthSASLFinal && string(cuerpo[4:]) == esperada
+}
+
+func TestAutenticarSCRAM(t *testing.T) {
+	verificadores, err := verificadoresSCRAM(map[string]string{"BI": "secreta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	casos := []struct {
+		usuario, clave string
+		ok             bool
+	}{
+		{"bi", "secreta", true},
+		{"bi", "otra", false},
+		{"nadie", "secreta", false},
+	}
+	for _, c := range casos {
+		servidor, cliente := net.Pipe()
+		s := &sesionPG{conn: servidor, r: bufio.NewReader(servidor), w: bufio.NewWriter(servidor), usuario: c.usuario}
+		resultado := make(chan error, 1)
+		go func() {
+			err := s.autenticarSCRAM(verificadores)
+			s.w.Flush()
+			resultado <- err
+		}()
+		aceptado, firmaValida := (&clientePG{t: t, c: cliente, r: bufio.NewReader(cliente)}).autenticar(c.clave)
+		err := <-resultado
+		servidor.Close()
+		cliente.Close()
+		if aceptado != c.ok || (err == nil) != c.ok || (c.ok && !firmaValida) {
+			t.Errorf("%s/%s: aceptado=%v firma=%v error=%v, se esperaba aceptado=%v", c.usuario, c.clave, aceptado, firmaValida, err, c.ok)
+		}
+	}
+}
+
+// Un usuario desconocido recibe siempre la misma sal, como uno real, y no
+// cuesta derivar un verificador nuevo en cada intento.
+func TestVerificadorDesconocido(t *testing.T) {
+	a, err := verificadorDesconocido("nadie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := verificadorDesconocido("NADIE")
+	c, _ := verificadorDesconocido("otro")
+	if !bytes.Equal(a.sal, b.sal) {
+		t.Error("dos intentos del mismo usuario deberían recibir la misma sal")
+	}
+	if bytes.Equal(a.sal, c.sal) {
+		t.Error("cada usuario desconocido debería recibir su propia sal")
+	}
+	real, _ := nuevoVerificadorSCRAM("secreta")
+	if len(a.sal) != len(real.sal) || a.iteraciones != real.iteraciones {
+		t.Errorf("sal de %d bytes y %d iteraciones, un usuario real tiene %d y %d", len(a.sal), a.iteraciones, len(real.sal), real.iteraciones)
+	}
+	if &a.storedKey[0] != &c.storedKey[0] {
+		t.Error("los usuarios desconocidos deberían compartir el verificador simulado")
+	}
+}
+
+func TestLeerPrimeroSCRAM(t *testing.T) {
+	for mensaje, ok := range map[string]bool{
+		"n,,n=,r=abc":                 true,
+		"y,,n=usuario,r=abc":          true,
+		"p=tls-server-end-point,,r=a": false,
+		"n,a=otro,n=,r=abc":           false,
+		"n,,n=":                       false,
+		"basura":                      false,
+	} {
+		if _, _, _, err := leerPrimeroSCRAM(mensaje); (err == nil) != ok {
+			t.Errorf("leerPrimeroSCRAM(%q): error %v, se esperaba válido=%v", mensaje, err, ok)
+		}
+	}
+}
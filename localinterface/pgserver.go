@@ -0,0 +1,1237 @@
+package localinterface
+
+/*
+MIT License
+
+Copyright (c) 2026 Juan Carlos Daille
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcboeker/go-duckdb"
+	log "github.com/sirupsen/logrus"
+
+	configuration "Veredarii/configuration"
+	"Veredarii/connection"
+)
+
+// Servidor compatible con el protocolo de PostgreSQL (versión 3.0). Los
+// DATA_SOURCE remotos aparecen como tablas red.fuente; las consultas que las
+// usan se resuelven con la consulta distribuida sobre /query/1.0.0 y el resto
+// (information_schema, pg_catalog) contra un catálogo local de tablas vacías.
+// Los resultados se envían siempre en formato texto.
+const (
+	pgVersionProtocolo = 196608
+	pgSolicitudSSL     = 80877103
+	pgSolicitudGSS     = 80877104
+	pgCancelacion      = 80877102
+	pgMaxMensaje       = 64 << 20
+)
+
+var parametrosPG = map[string]string{
+	"server_version":              "14.0",
+	"server_encoding":             "UTF8",
+	"client_encoding":             "UTF8",
+	"DateStyle":                   "ISO, MDY",
+	"TimeZone":                    "UTC",
+	"integer_datetimes":           "on",
+	"standard_conforming_strings": "on",
+	"IntervalStyle":               "postgres",
+	"is_superuser":                "off",
+}
+
+// Valores de SHOW además de los parámetros informados al conectar.
+var showPG = map[string]string{
+	"transaction_isolation":       "read committed",
+	"transaction isolation level": "read committed",
+	"search_path":                 `"$user", public`,
+	"max_identifier_length":       "63",
+}
+
+// Comandos que se aceptan sin efecto: no hay transacciones ni sesión que
+// configurar.
+var comandosPG = map[string]string{
+	"SET":        "SET",
+	"RESET":      "RESET",
+	"BEGIN":      "BEGIN",
+	"START":      "START TRANSACTION",
+	"COMMIT":     "COMMIT",
+	"END":        "COMMIT",
+	"ROLLBACK":   "ROLLBACK",
+	"DISCARD":    "DISCARD ALL",
+	"DEALLOCATE": "DEALLOCATE",
+	"UNLISTEN":   "UNLISTEN",
+	"CLOSE":      "CLOSE CURSOR",
+}
+
+// Tipos de DuckDB y su OID en PostgreSQL. Lo que no está viaja como text.
+var oidsPG = map[string]uint32{
+	"BOOLEAN":                  16,
+	"BLOB":                     17,
+	"TINYINT":                  21,
+	"UTINYINT":                 21,
+	"SMALLINT":                 21,
+	"USMALLINT":                23,
+	"INTEGER":                  23,
+	"UINTEGER":                 20,
+	"BIGINT":                   20,
+	"UBIGINT":                  1700,
+	"HUGEINT":                  1700,
+	"UHUGEINT":                 1700,
+	"DECIMAL":                  1700,
+	"FLOAT":                    700,
+	"DOUBLE":                   701,
+	"DATE":                     1082,
+	"TIME":                     1083,
+	"TIMESTAMP":                1114,
+	"TIMESTAMP_S":              1114,
+	"TIMESTAMP_MS":             1114,
+	"TIMESTAMP_NS":             1114,
+	"TIMESTAMPTZ":              1184,
+	"TIMESTAMP WITH TIME ZONE": 1184,
+	"INTERVAL":                 1186,
+	"UUID":                     2950,
+	"JSON":                     114,
+}
+
+var largosPG = map[uint32]int16{16: 1, 20: 8, 21: 2, 23: 4, 700: 4, 701: 8, 1082: 4, 1083: 8, 1114: 8, 1184: 8, 1186: 16, 2950: 16}
+
+func startPostgres() {
+	config := configuration.CM.GetConfig().LocalInterface.Postgres
+	if config.Port == "" {
+		return
+	}
+	credenciales, err := credencialesLocales()
+	if err == nil && credenciales == nil {
+		err = errors.New("falta localInterface.credentials")
+	}
+	if err != nil {
+		log.Error("El servidor PostgreSQL no se inicia: ", err)
+		return
+	}
+	verificadores, err := verificadoresSCRAM(credenciales)
+	if err != nil {
+		log.Error("El servidor PostgreSQL no se inicia: ", err)
+		return
+	}
+	direccion := config.Address
+	if direccion == "" {
+		direccion = "127.0.0.1"
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(direccion, config.Port))
+	if err != nil {
+		log.Error("Error iniciando el servidor PostgreSQL: ", err)
+		return
+	}
+	fmt.Printf("PostgreSQL escuchando en %s\n", ln.Addr())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Error("Error aceptando conexión PostgreSQL: ", err)
+				return
+			}
+			go atenderPG(conn, verificadores)
+		}
+	}()
+}
+
+type sesionPG struct {
+	conn       net.Conn
+	r          *bufio.Reader
+	w          *bufio.Writer
+	usuario    string
+	clave      [8]byte
+	catalogo   *sql.DB
+	sentencias map[string]*sentenciaPG
+	portales   map[string]*portalPG
+	// fallida descarta los mensajes del protocolo extendido hasta el
+	// siguiente Sync, después de un error.
+	fallida bool
+	// interrumpir cancela la consulta que se está leyendo; lo usa
+	// CancelRequest desde otra conexión.
+	mu          sync.Mutex
+	interrumpir context.CancelFunc
+}
+
+type sentenciaPG struct {
+	query string
+	tipos []uint32
+}
+
+// portalPG es una sentencia con sus parámetros. Las consultas se abren como
+// cursor al describir o ejecutar el portal y las filas se leen a medida que
+// el cliente las pide.
+type portalPG struct {
+	query    string
+	abierto  bool
+	vacia    bool
+	show     bool
+	etiqueta string
+	cursor   *cursorPG
+}
+
+func (p *portalPG) cerrar() {
+	if p.cursor != nil {
+		p.cursor.cerrar()
+		p.cursor = nil
+	}
+}
+
+type columnaPG struct {
+	nombre string
+	oid    uint32
+}
+
+// sesionesPG relaciona la clave enviada en BackendKeyData con su sesión,
+// para atender CancelRequest.
+var sesionesPG = struct {
+	sync.Mutex
+	m map[[8]byte]*sesionPG
+}{m: map[[8]byte]*sesionPG{}}
+
+func cancelarSesionPG(clave [8]byte) {
+	sesionesPG.Lock()
+	s := sesionesPG.m[clave]
+	sesionesPG.Unlock()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.interrumpir != nil {
+		s.interrumpir()
+	}
+	s.mu.Unlock()
+}
+
+func (s *sesionPG) enCurso(cancelar context.CancelFunc) {
+	s.mu.Lock()
+	s.interrumpir = cancelar
+	s.mu.Unlock()
+}
+
+type mensajePG struct {
+	tipo   byte
+	cuerpo []byte
+}
+
+func atenderPG(conn net.Conn, verificadores map[string]verificadorSCRAM) {
+	defer conn.Close()
+	s := &sesionPG{
+		conn:       conn,
+		r:          bufio.NewReader(conn),
+		w:          bufio.NewWriter(conn),
+		sentencias: map[string]*sentenciaPG{},
+		portales:   map[string]*portalPG{},
+	}
+	if err := s.iniciar(verificadores); err != nil {
+		if err != io.EOF {
+			log.Warn("Conexión PostgreSQL rechazada desde ", conn.RemoteAddr(), ": ", err)
+		}
+		return
+	}
+	log.Info("Conexión PostgreSQL de ", s.usuario, " desde ", conn.RemoteAddr())
+	sesionesPG.Lock()
+	sesionesPG.m[s.clave] = s
+	sesionesPG.Unlock()
+	defer func() {
+		sesionesPG.Lock()
+		delete(sesionesPG.m, s.clave)
+		sesionesPG.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		for _, p := range s.portales {
+			p.cerrar()
+		}
+		if s.catalogo != nil {
+			s.catalogo.Close()
+		}
+	}()
+
+	// Los mensajes se leen en otra goroutine: si el cliente cierra la
+	// conexión mientras corre una consulta, la consulta se cancela.
+	mensajes := make(chan mensajePG)
+	go func() {
+		defer close(mensajes)
+		defer cancel()
+		for {
+			tipo, cuerpo, err := s.leerMensaje()
+			if err != nil {
+				return
+			}
+			select {
+			case mensajes <- mensajePG{tipo, cuerpo}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for m := range mensajes {
+		tipo, cuerpo := m.tipo, m.cuerpo
+		var err error
+		if s.fallida && tipo != 'S' && tipo != 'X' {
+			continue
+		}
+		switch tipo {
+		case 'Q':
+			s.consultaSimple(ctx, cadena(cuerpo))
+		case 'P':
+			err = s.parse(cuerpo)
+		case 'B':
+			err = s.bind(cuerpo)
+		case 'D':
+			err = s.describe(ctx, cuerpo)
+		case 'E':
+			err = s.execute(ctx, cuerpo)
+		case 'C':
+			err = s.cerrar(cuerpo)
+		case 'S':
+			err = s.listo()
+		case 'H':
+			err = s.w.Flush()
+		case 'X':
+			return
+		default:
+			err = s.fallar("08P01", fmt.Sprintf("mensaje no soportado: %q", tipo))
+		}
+		if err != nil {
+			log.Debug("Conexión PostgreSQL terminada: ", err)
+			return
+		}
+	}
+}
+
+// iniciar atiende el arranque: descarta SSL y GSS, autentica con
+// SCRAM-SHA-256 e informa los parámetros.
+func (s *sesionPG) iniciar(verificadores map[string]verificadorSCRAM) error {
+	var parametros map[string]string
+	for parametros == nil {
+		var largo uint32
+		if err := binary.Read(s.r, binary.BigEndian, &largo); err != nil {
+			return err
+		}
+		if largo < 8 || largo > 10000 {
+			return fmt.Errorf("mensaje de inicio inválido")
+		}
+		cuerpo := make([]byte, largo-4)
+		if _, err := io.ReadFull(s.r, cuerpo); err != nil {
+			return err
+		}
+		switch binary.BigEndian.Uint32(cuerpo) {
+		case pgSolicitudSSL, pgSolicitudGSS:
+			if _, err := s.conn.Write([]byte{'N'}); err != nil {
+				return err
+			}
+		case pgCancelacion:
+			if len(cuerpo) == 12 {
+				var clave [8]byte
+				copy(clave[:], cuerpo[4:])
+				cancelarSesionPG(clave)
+			}
+			return io.EOF
+		case pgVersionProtocolo:
+			parametros = map[string]string{}
+			partes := strings.Split(string(cuerpo[4:]), "\x00")
+			for i := 0; i+1 < len(partes); i += 2 {
+				parametros[partes[i]] = partes[i+1]
+			}
+		default:
+			return fmt.Errorf("versión de protocolo no soportada")
+		}
+	}
+	s.usuario = parametros["user"]
+
+	if err := s.autenticarSCRAM(verificadores); err != nil {
+		return err
+	}
+	s.enviar('R', entero32(0))
+	for nombre, valor := range parametrosPG {
+		s.enviar('S', append(append([]byte(nombre), 0), append([]byte(valor), 0)...))
+	}
+	s.enviar('S', append([]byte("application_name\x00"), append([]byte(parametros["application_name"]), 0)...))
+	rand.Read(s.clave[:])
+	s.enviar('K', s.clave[:])
+	return s.listo()
+}
+
+func (s *sesionPG) consultaSimple(ctx context.Context, query string) {
+	sentencias := separarSentencias(query)
+	if len(sentencias) == 0 {
+		s.enviar('I', nil)
+	}
+	for _, sentencia := range sentencias {
+		p := &portalPG{query: sentencia}
+		if err := s.abrir(ctx, p); err != nil {
+			s.fallarConsulta(err)
+			break
+		}
+		if p.cursor != nil {
+			s.enviar('T', descripcionFilas(p.cursor.columnas))
+		}
+		if err := s.enviarFilas(p, 0); err != nil || s.fallida {
+			break
+		}
+	}
+	s.listo()
+}
+
+// abrir prepara la ejecución del portal la primera vez que se describe o se
+// ejecuta.
+func (s *sesionPG) abrir(ctx context.Context, p *portalPG) error {
+	if p.abierto {
+		return nil
+	}
+	sentencia := strings.TrimSpace(p.query)
+	if sentencia == "" {
+		p.abierto, p.vacia = true, true
+		return nil
+	}
+	palabra := strings.ToUpper(strings.Fields(sentencia)[0])
+	if etiqueta, ok := comandosPG[palabra]; ok {
+		p.abierto, p.etiqueta = true, etiqueta
+		return nil
+	}
+	if palabra == "SHOW" {
+		sentencia, p.show = consultaShow(strings.TrimSpace(sentencia[len("SHOW"):])), true
+	}
+	c, err := s.abrirCursor(ctx, sentencia)
+	if err != nil {
+		return err
+	}
+	p.abierto, p.cursor = true, c
+	return nil
+}
+
+// consultaShow responde SHOW con una consulta al catálogo que devuelve el
+// valor del parámetro.
+func consultaShow(nombre string) string {
+	valor, ok := parametrosPG[nombre]
+	if !ok {
+		valor = showPG[strings.ToLower(nombre)]
+	}
+	return `SELECT '` + strings.ReplaceAll(valor, "'", "''") + `' AS "` + strings.ReplaceAll(nombre, `"`, `""`) + `"`
+}
+
+// enviarFilas envía hasta maximo filas del portal, o todas con 0. Si quedan
+// filas el portal queda suspendido con el cursor abierto. Solo devuelve los
+// errores de la conexión; los de la consulta se informan al cliente.
+func (s *sesionPG) enviarFilas(p *portalPG, maximo int32) error {
+	if p.vacia {
+		return s.enviar('I', nil)
+	}
+	c := p.cursor
+	if c == nil {
+		return s.enviar('C', append([]byte(p.etiqueta), 0))
+	}
+	s.enCurso(c.cancelar)
+	defer s.enCurso(nil)
+	for enviadas := int32(0); maximo <= 0 || enviadas < maximo; enviadas++ {
+		fila, err := c.siguiente()
+		if err != nil {
+			p.cerrar()
+			return s.fallarConsulta(err)
+		}
+		if fila == nil {
+			p.cursor, p.etiqueta = nil, "SELECT 0"
+			if err := c.cerrar(); err != nil {
+				return s.fallarConsulta(err)
+			}
+			etiqueta := fmt.Sprintf("SELECT %d", enviadas)
+			if p.show {
+				etiqueta = "SHOW"
+			}
+			return s.enviar('C', append([]byte(etiqueta), 0))
+		}
+		if err := s.enviar('D', filaDatos(fila)); err != nil {
+			return err
+		}
+	}
+	return s.enviar('s', nil)
+}
+
+// cursorPG mantiene abierto el resultado de una consulta. La consulta
+// distribuida entrega sus filas dentro de un callback, así que corre en su
+// propia goroutine, que espera en liberar a que el portal termine.
+type cursorPG struct {
+	rows      *sql.Rows
+	columnas  []columnaPG
+	valores   []interface{}
+	punteros  []interface{}
+	terminado bool
+	cancelar  context.CancelFunc
+	liberar   chan struct{}
+	fin       chan error
+}
+
+func (s *sesionPG) abrirCursor(ctx context.Context, sentencia string) (*cursorPG, error) {
+	final, remotas, err := connection.TablasRemotas(sentencia)
+	if err != nil {
+		return nil, err
+	}
+	var catalogo *sql.DB
+	if remotas == 0 {
+		if catalogo, err = s.abrirCatalogo(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancelar := context.WithCancel(ctx)
+	c := &cursorPG{cancelar: cancelar, liberar: make(chan struct{}), fin: make(chan error, 1)}
+	abierto := make(chan *sql.Rows)
+	leer := func(rows *sql.Rows) error {
+		abierto <- rows
+		<-c.liberar
+		return nil
+	}
+	go func() {
+		if remotas > 0 {
+			log.Debug("Consulta PostgreSQL distribuida: ", final)
+			_, err := connection.RecorrerDistribuida(ctx, final, leer)
+			c.fin <- err
+			return
+		}
+		rows, err := catalogo.QueryContext(ctx, sentencia)
+		if err == nil {
+			leer(rows)
+			rows.Close()
+		}
+		c.fin <- err
+	}()
+
+	s.enCurso(cancelar)
+	defer s.enCurso(nil)
+	select {
+	case rows := <-abierto:
+		c.rows = rows
+		if c.columnas, err = columnasPG(rows); err != nil {
+			c.cerrar()
+			return nil, err
+		}
+		c.valores = make([]interface{}, len(c.columnas))
+		c.punteros = make([]interface{}, len(c.columnas))
+		for i := range c.valores {
+			c.punteros[i] = &c.valores[i]
+		}
+		return c, nil
+	case err := <-c.fin:
+		cancelar()
+		if err == nil {
+			err = errors.New("la consulta no devolvió resultados")
+		}
+		return nil, err
+	}
+}
+
+// siguiente devuelve la próxima fila, o nil al terminar el resultado.
+func (c *cursorPG) siguiente() ([][]byte, error) {
+	if !c.rows.Next() {
+		c.terminado = true
+		return nil, c.rows.Err()
+	}
+	if err := c.rows.Scan(c.punteros...); err != nil {
+		return nil, err
+	}
+	fila := make([][]byte, len(c.valores))
+	for i, v := range c.valores {
+		fila[i] = textoPG(v, c.columnas[i].oid)
+	}
+	return fila, nil
+}
+
+// cerrar termina la consulta; si quedaban filas sin leer, la cancela.
+func (c *cursorPG) cerrar() error {
+	if !c.terminado {
+		c.cancelar()
+	}
+	close(c.liberar)
+	err := <-c.fin
+	c.cancelar()
+	if !c.terminado {
+		return nil
+	}
+	return err
+}
+
+func (s *sesionPG) abrirCatalogo(ctx context.Context) (*sql.DB, error) {
+	if s.catalogo == nil {
+		db, err := connection.AbrirCatalogo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.catalogo = db
+	}
+	return s.catalogo, nil
+}
+
+func columnasPG(rows *sql.Rows) ([]columnaPG, error) {
+	tipos, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columnas := make([]columnaPG, len(tipos))
+	for i, t := range tipos {
+		columnas[i] = columnaPG{nombre: t.Name(), oid: oidPG(strings.ToUpper(t.DatabaseTypeName()))}
+	}
+	return columnas, nil
+}
+
+func oidPG(tipo string) uint32 {
+	if i := strings.IndexByte(tipo, '('); i > 0 {
+		tipo = tipo[:i]
+	}
+	if oid, ok := oidsPG[tipo]; ok {
+		return oid
+	}
+	return 25
+}
+
+// textoPG codifica un valor en el formato texto de PostgreSQL; nil es NULL.
+func textoPG(v interface{}, oid uint32) []byte {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		if x {
+			return []byte("t")
+		}
+		return []byte("f")
+	case []byte:
+		switch {
+		case oid == 17:
+			return []byte(`\x` + hex.EncodeToString(x))
+		case oid == 2950 && len(x) == 16:
+			u := duckdb.UUID(x)
+			return []byte(u.String())
+		}
+		return x
+	case duckdb.Decimal:
+		return []byte(x.String())
+	case duckdb.Interval:
+		return []byte(intervaloPG(x))
+	case string:
+		return []byte(x)
+	case float32:
+		return []byte(numeroPG(float64(x), 32))
+	case float64:
+		return []byte(numeroPG(x, 64))
+	case time.Time:
+		switch oid {
+		case 1082:
+			return []byte(x.Format("2006-01-02"))
+		case 1083:
+			return []byte(x.Format("15:04:05.999999"))
+		case 1184:
+			return []byte(x.Format("2006-01-02 15:04:05.999999-07"))
+		}
+		return []byte(x.Format("2006-01-02 15:04:05.999999"))
+	case map[string]interface{}, []interface{}:
+		datos, _ := json.Marshal(x)
+		return datos
+	case fmt.Stringer:
+		return []byte(x.String())
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+func intervaloPG(i duckdb.Interval) string {
+	micros := i.Micros
+	signo := ""
+	if micros < 0 {
+		signo, micros = "-", -micros
+	}
+	segundos := micros / 1e6
+	return fmt.Sprintf("%d mons %d days %s%02d:%02d:%02d.%06d", i.Months, i.Days, signo,
+		segundos/3600, segundos/60%60, segundos%60, micros%1e6)
+}
+
+func numeroPG(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+// Protocolo extendido.
+
+func (s *sesionPG) parse(cuerpo []byte) error {
+	l := &lectorPG{b: cuerpo}
+	nombre, query := l.cadena(), l.cadena()
+	tipos := make([]uint32, l.cantidad(4))
+	for i := range tipos {
+		tipos[i] = uint32(l.entero32())
+	}
+	if l.err != nil {
+		return s.fallar("08P01", "mensaje Parse inválido")
+	}
+	if len(separarSentencias(query)) > 1 {
+		return s.fallar("42601", "no se permiten varias sentencias en una consulta preparada")
+	}
+	s.sentencias[nombre] = &sentenciaPG{query: query, tipos: tipos}
+	return s.enviar('1', nil)
+}
+
+func (s *sesionPG) bind(cuerpo []byte) error {
+	l := &lectorPG{b: cuerpo}
+	portal, nombre := l.cadena(), l.cadena()
+	formatos := make([]int16, l.cantidad(2))
+	for i := range formatos {
+		formatos[i] = l.entero16()
+	}
+	valores := make([]*string, l.cantidad(4))
+	st, ok := s.sentencias[nombre]
+	if !ok {
+		return s.fallar("26000", "sentencia preparada no encontrada: "+nombre)
+	}
+	for i := range valores {
+		largo := l.entero32()
+		if largo < 0 {
+			continue
+		}
+		dato := l.bytes(int(largo))
+		formato := int16(0)
+		if len(formatos) == 1 {
+			formato = formatos[0]
+		} else if i < len(formatos) {
+			formato = formatos[i]
+		}
+		var oid uint32
+		if i < len(st.tipos) {
+			oid = st.tipos[i]
+		}
+		texto, err := parametroPG(dato, formato, oid)
+		if err != nil {
+			return s.fallar("22P03", err.Error())
+		}
+		valores[i] = &texto
+	}
+	resultados := make([]int16, l.cantidad(2))
+	for i := range resultados {
+		resultados[i] = l.entero16()
+		if resultados[i] != 0 {
+			return s.fallar("0A000", "solo se admite el formato texto en los resultados")
+		}
+	}
+	if l.err != nil {
+		return s.fallar("08P01", "mensaje Bind inválido")
+	}
+	query, err := sustituirParametros(st.query, valores, st.tipos)
+	if err != nil {
+		return s.fallar("08P01", err.Error())
+	}
+	if anterior, ok := s.portales[portal]; ok {
+		anterior.cerrar()
+	}
+	s.portales[portal] = &portalPG{query: query}
+	return s.enviar('2', nil)
+}
+
+// parametroPG devuelve el valor de un parámetro como texto. En binario solo
+// se aceptan los tipos numéricos, bool y texto.
+func parametroPG(dato []byte, formato int16, oid uint32) (string, error) {
+	if formato == 0 {
+		return string(dato), nil
+	}
+	switch {
+	case oid == 16 && len(dato) == 1:
+		return strconv.FormatBool(dato[0] != 0), nil
+	case oid == 21 && len(dato) == 2:
+		return strconv.Itoa(int(int16(binary.BigEndian.Uint16(dato)))), nil
+	case oid == 23 && len(dato) == 4:
+		return strconv.Itoa(int(int32(binary.BigEndian.Uint32(dato)))), nil
+	case oid == 20 && len(dato) == 8:
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(dato)), 10), nil
+	case oid == 700 && len(dato) == 4:
+		return numeroPG(float64(math.Float32frombits(binary.BigEndian.Uint32(dato))), 32), nil
+	case oid == 701 && len(dato) == 8:
+		return numeroPG(math.Float64frombits(binary.BigEndian.Uint64(dato)), 64), nil
+	case oid == 25 || oid == 1043:
+		return string(dato), nil
+	}
+	return "", fmt.Errorf("parámetro binario no soportado para el tipo %d", oid)
+}
+
+func (s *sesionPG) describe(ctx context.Context, cuerpo []byte) error {
+	l := &lectorPG{b: cuerpo}
+	clase, nombre := l.byte(), l.cadena()
+	if l.err != nil {
+		return s.fallar("08P01", "mensaje Describe inválido")
+	}
+	if clase == 'S' {
+		st, ok := s.sentencias[nombre]
+		if !ok {
+			return s.fallar("26000", "sentencia preparada no encontrada: "+nombre)
+		}
+		return s.describirSentencia(ctx, st)
+	}
+
+	p, ok := s.portales[nombre]
+	if !ok {
+		return s.fallar("34000", "portal no encontrado: "+nombre)
+	}
+	// La consulta se abre al describir el portal: los tipos de las columnas
+	// salen de la ejecución, cuyas filas se leen después con Execute.
+	if err := s.abrir(ctx, p); err != nil {
+		return s.fallarConsulta(err)
+	}
+	if p.cursor == nil {
+		return s.enviar('n', nil)
+	}
+	return s.enviar('T', descripcionFilas(p.cursor.columnas))
+}
+
+// describirSentencia informa los parámetros y las columnas de una sentencia
+// sin ejecutarla: la consulta, con los parámetros en NULL, se prepara contra
+// el catálogo, que tiene las tablas remotas con sus columnas.
+func (s *sesionPG) describirSentencia(ctx context.Context, st *sentenciaPG) error {
+	parametros := contarParametros(st.query)
+	if len(st.tipos) > parametros {
+		parametros = len(st.tipos)
+	}
+	descripcion := entero16(int16(parametros))
+	for i := 0; i < parametros; i++ {
+		oid := uint32(25)
+		if i < len(st.tipos) && st.tipos[i] != 0 {
+			oid = st.tipos[i]
+		}
+		descripcion = append(descripcion, entero32(int32(oid))...)
+	}
+	if err := s.enviar('t', descripcion); err != nil {
+		return err
+	}
+
+	sentencia := strings.TrimSpace(st.query)
+	if sentencia == "" {
+		return s.enviar('n', nil)
+	}
+	palabra := strings.ToUpper(strings.Fields(sentencia)[0])
+	if _, ok := comandosPG[palabra]; ok {
+		return s.enviar('n', nil)
+	}
+	if palabra == "SHOW" {
+		return s.enviar('T', descripcionFilas([]columnaPG{{nombre: strings.TrimSpace(sentencia[len("SHOW"):]), oid: 25}}))
+	}
+	query, err := sustituirParametros(st.query, make([]*string, parametros), st.tipos)
+	if err != nil {
+		return s.fallar("08P01", err.Error())
+	}
+	catalogo, err := s.abrirCatalogo(ctx)
+	if err != nil {
+		return s.fallarConsulta(err)
+	}
+	rows, err := catalogo.QueryContext(ctx, "SELECT * FROM ("+query+"\n) LIMIT 0")
+	if err != nil {
+		return s.fallarConsulta(err)
+	}
+	defer rows.Close()
+	columnas, err := columnasPG(rows)
+	if err != nil {
+		return s.fallarConsulta(err)
+	}
+	return s.enviar('T', descripcionFilas(columnas))
+}
+
+func (s *sesionPG) execute(ctx context.Context, cuerpo []byte) error {
+	l := &lectorPG{b: cuerpo}
+	nombre, maximo := l.cadena(), l.entero32()
+	if l.err != nil {
+		return s.fallar("08P01", "mensaje Execute inválido")
+	}
+	p, ok := s.portales[nombre]
+	if !ok {
+		return s.fallar("34000", "portal no encontrado: "+nombre)
+	}
+	if err := s.abrir(ctx, p); err != nil {
+		return s.fallarConsulta(err)
+	}
+	return s.enviarFilas(p, maximo)
+}
+
+func (s *sesionPG) cerrar(cuerpo []byte) error {
+	l := &lectorPG{b: cuerpo}
+	clase, nombre := l.byte(), l.cadena()
+	if clase == 'S' {
+		delete(s.sentencias, nombre)
+	} else if p, ok := s.portales[nombre]; ok {
+		p.cerrar()
+		delete(s.portales, nombre)
+	}
+	return s.enviar('3', nil)
+}
+
+// Mensajes.
+
+func (s *sesionPG) leerMensaje() (byte, []byte, error) {
+	tipo, err := s.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var largo uint32
+	if err := binary.Read(s.r, binary.BigEndian, &largo); err != nil {
+		return 0, nil, err
+	}
+	if largo < 4 || largo > pgMaxMensaje {
+		return 0, nil, fmt.Errorf("mensaje de %d bytes", largo)
+	}
+	cuerpo := make([]byte, largo-4)
+	_, err = io.ReadFull(s.r, cuerpo)
+	return tipo, cuerpo, err
+}
+
+func (s *sesionPG) enviar(tipo byte, cuerpo []byte) error {
+	s.w.WriteByte(tipo)
+	binary.Write(s.w, binary.BigEndian, uint32(len(cuerpo)+4))
+	_, err := s.w.Write(cuerpo)
+	return err
+}
+
+// listo termina un ciclo de consulta: el protocolo simple después de cada
+// Query y el extendido en Sync, donde se levanta el error pendiente.
+func (s *sesionPG) listo() error {
+	s.fallida = false
+	s.enviar('Z', []byte{'I'})
+	return s.w.Flush()
+}
+
+// fallar envía un ErrorResponse. En el protocolo extendido, lo que sigue
+// hasta Sync se descarta.
+func (s *sesionPG) fallar(codigo, mensaje string) error {
+	s.fallida = true
+	var cuerpo []byte
+	for _, campo := range [][2]string{{"S", "ERROR"}, {"V", "ERROR"}, {"C", codigo}, {"M", mensaje}} {
+		cuerpo = append(cuerpo, campo[0][0])
+		cuerpo = append(append(cuerpo, campo[1]...), 0)
+	}
+	return s.enviar('E', append(cuerpo, 0))
+}
+
+func (s *sesionPG) fallarConsulta(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return s.fallar("57014", "la consulta fue cancelada")
+	}
+	log.Warn("Error en consulta PostgreSQL de ", s.usuario, ": ", err)
+	return s.fallar(codigoPG(err), err.Error())
+}
+
+// codigoPG traduce los errores de consulta a SQLSTATE.
+func codigoPG(err error) string {
+	var qe *connection.QueryError
+	if errors.As(err, &qe) {
+		switch qe.Code {
+		case "QUERY_REJECTED":
+			return "42000"
+		case "NOT_FOUND":
+			return "42P01"
+		case "TIMEOUT", "CANCELED":
+			return "57014"
+		case "QUOTA_EXCEEDED":
+			return "53400"
+		case "ROW_LIMIT_EXCEEDED", "BYTE_LIMIT_EXCEEDED", "MEMORY_LIMIT_EXCEEDED":
+			return "54000"
+		case "UNSUPPORTED_FORMAT":
+			return "0A000"
+		}
+	}
+	switch {
+	case errors.Is(err, connection.ErrFuenteRemota):
+		return "08006"
+	case strings.Contains(err.Error(), "Catalog Error"):
+		return "42P01"
+	case strings.Contains(err.Error(), "Parser Error"):
+		return "42601"
+	case strings.Contains(err.Error(), "Permission Error"):
+		return "42501"
+	}
+	return "XX000"
+}
+
+func descripcionFilas(columnas []columnaPG) []byte {
+	cuerpo := entero16(int16(len(columnas)))
+	for _, c := range columnas {
+		cuerpo = append(append(cuerpo, c.nombre...), 0)
+		cuerpo = append(cuerpo, entero32(0)...)
+		cuerpo = append(cuerpo, entero16(0)...)
+		cuerpo = append(cuerpo, entero32(int32(c.oid))...)
+		largo, ok := largosPG[c.oid]
+		if !ok {
+			largo = -1
+		}
+		cuerpo = append(cuerpo, entero16(largo)...)
+		cuerpo = append(cuerpo, entero32(-1)...)
+		cuerpo = append(cuerpo, entero16(0)...)
+	}
+	return cuerpo
+}
+
+func filaDatos(valores [][]byte) []byte {
+	cuerpo := entero16(int16(len(valores)))
+	for _, v := range valores {
+		if v == nil {
+			cuerpo = append(cuerpo, entero32(-1)...)
+			continue
+		}
+		cuerpo = append(cuerpo, entero32(int32(len(v)))...)
+		cuerpo = append(cuerpo, v...)
+	}
+	return cuerpo
+}
+
+func entero16(v int16) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(v))
+}
+
+func entero32(v int32) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(v))
+}
+
+func cadena(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// lectorPG lee los campos de un mensaje; el primer error se conserva y los
+// campos siguientes quedan en cero.
+type lectorPG struct {
+	b   []byte
+	err error
+}
+
+func (l *lectorPG) tomar(n int) []byte {
+	if l.err != nil || n < 0 || n > len(l.b) {
+		l.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	res := l.b[:n]
+	l.b = l.b[n:]
+	return res
+}
+
+func (l *lectorPG) cadena() string {
+	i := strings.IndexByte(string(l.b), 0)
+	if l.err != nil || i < 0 {
+		l.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	return string(l.tomar(i + 1)[:i])
+}
+
+func (l *lectorPG) byte() byte {
+	if b := l.tomar(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (l *lectorPG) entero16() int16 {
+	if b := l.tomar(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (l *lectorPG) entero32() int32 {
+	if b := l.tomar(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// cantidad lee el largo de una lista de campos de al menos tam bytes cada
+// uno. Un largo negativo o mayor que lo que queda del mensaje es un error y
+// vale cero, para no reservar memoria por lo que diga el cliente.
+func (l *lectorPG) cantidad(tam int) int {
+	n := int(l.entero16())
+	if l.err == nil && (n < 0 || n*tam > len(l.b)) {
+		l.err = io.ErrUnexpectedEOF
+	}
+	if l.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (l *lectorPG) bytes(n int) []byte {
+	return l.tomar(n)
+}
+
+// Texto SQL.
+
+// finCita devuelve dónde termina la cadena, el identificador entre comillas
+// o el comentario que empieza en i, o i si ahí no empieza ninguno.
+func finCita(q string, i int) int {
+	switch {
+	case q[i] == '\'' || q[i] == '"':
+		for j := i + 1; j < len(q); j++ {
+			if q[j] != q[i] {
+				continue
+			}
+			if j+1 < len(q) && q[j+1] == q[i] {
+				j++
+				continue
+			}
+			return j + 1
+		}
+		return len(q)
+	case strings.HasPrefix(q[i:], "--"):
+		if k := strings.IndexByte(q[i:], '\n'); k >= 0 {
+			return i + k + 1
+		}
+		return len(q)
+	case strings.HasPrefix(q[i:], "/*"):
+		if k := strings.Index(q[i+2:], "*/"); k >= 0 {
+			return i + 2 + k + 2
+		}
+		return len(q)
+	}
+	return i
+}
+
+func separarSentencias(q string) []string {
+	var sentencias []string
+	inicio := 0
+	for i := 0; i < len(q); {
+		if j := finCita(q, i); j > i {
+			i = j
+			continue
+		}
+		if q[i] == ';' {
+			sentencias = append(sentencias, q[inicio:i])
+			inicio = i + 1
+		}
+		i++
+	}
+	sentencias = append(sentencias, q[inicio:])
+
+	var res []string
+	for _, s := range sentencias {
+		if strings.TrimSpace(s) != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// recorrerParametros llama a f con la posición, el largo y el número de cada
+// $n fuera de cadenas y comentarios.
+func recorrerParametros(q string, f func(inicio, fin, n int) error) error {
+	for i := 0; i < len(q); {
+		if j := finCita(q, i); j > i {
+			i = j
+			continue
+		}
+		if q[i] == '$' {
+			j := i + 1
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				n, _ := strconv.Atoi(q[i+1 : j])
+				if err := f(i, j, n); err != nil {
+					return err
+				}
+				i = j
+				continue
+			}
+		}
+		i++
+	}
+	return nil
+}
+
+func contarParametros(q string) int {
+	maximo := 0
+	recorrerParametros(q, func(_, _, n int) error {
+		maximo = max(maximo, n)
+		return nil
+	})
+	return maximo
+}
+
+// sustituirParametros reemplaza cada $n por su valor como literal SQL. Los
+// tipos numéricos declarados van sin comillas si el valor es un número.
+func sustituirParametros(q string, valores []*string, tipos []uint32) (string, error) {
+	var b strings.Builder
+	ultimo := 0
+	err := recorrerParametros(q, func(inicio, fin, n int) error {
+		if n < 1 || n > len(valores) {
+			return fmt.Errorf("parámetro $%d sin valor", n)
+		}
+		b.WriteString(q[ultimo:inicio])
+		ultimo = fin
+		valor := valores[n-1]
+		if valor == nil {
+			b.WriteString("NULL")
+			return nil
+		}
+		literal := "'" + strings.ReplaceAll(*valor, "'", "''") + "'"
+		if n <= len(tipos) && numericoPG(tipos[n-1]) {
+			if _, err := strconv.ParseFloat(*valor, 64); err == nil {
+				// NaN, Infinity y los hexadecimales no son literales
+				// numéricos en SQL: sin comillas serían identificadores.
+				if strings.Trim(*valor, "0123456789+-.eE") != "" {
+					literal += "::DOUBLE"
+				} else {
+					literal = *valor
+				}
+			}
+		}
+		b.WriteString(literal)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(q[ultimo:])
+	return b.String(), nil
+}
+
+func numericoPG(oid uint32) bool {
+	switch oid {
+	case 20, 21, 23, 700, 701, 1700:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package localinterface
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestSepararSentencias(t *testing.T) {
+	casos := map[string][]string{
+		"SELECT 1":                        {"SELECT 1"},
+		"SELECT 1; SELECT 2;":             {"SELECT 1", " SELECT 2"},
+		"SELECT ';'; SELECT 2":            {"SELECT ';'", " SELECT 2"},
+		`SELECT "a;b" FROM t`:             {`SELECT "a;b" FROM t`},
+		"SELECT 'it''s; ok'":              {"SELECT 'it''s; ok'"},
+		"SELECT 1 -- fin; no\n; SELECT 2": {"SELECT 1 -- fin; no\n", " SELECT 2"},
+		"SELECT /* ; */ 1":                {"SELECT /* ; */ 1"},
+		" ; ;":                            nil,
+		"SELECT 'sin cerrar; SELECT 2":    {"SELECT 'sin cerrar; SELECT 2"},
+	}
+	for query, esperado := range casos {
+		if obtenido := separarSentencias(query); !reflect.DeepEqual(obtenido, esperado) {
+			t.Errorf("separarSentencias(%q) = %q, se esperaba %q", query, obtenido, esperado)
+		}
+	}
+}
+
+func TestSustituirParametros(t *testing.T) {
+	texto := func(s string) *string { return &s }
+	casos := []struct {
+		query    string
+		valores  []*string
+		tipos    []uint32
+		esperado string
+	}{
+		{"SELECT $1", []*string{texto("a")}, nil, "SELECT 'a'"},
+		{"SELECT $1, $2", []*string{texto("7"), nil}, []uint32{23, 25}, "SELECT 7, NULL"},
+		{"SELECT $1", []*string{texto("7; DROP")}, []uint32{23}, "SELECT '7; DROP'"},
+		{"SELECT $1", []*string{texto("o'neil")}, nil, "SELECT 'o''neil'"},
+		{"SELECT '$1', $1 -- $2\n", []*string{texto("x")}, nil, "SELECT '$1', 'x' -- $2\n"},
+		{"SELECT $2, $1", []*string{texto("a"), texto("b")}, nil, "SELECT 'b', 'a'"},
+		{"SELECT $1", []*string{texto("-1.5e3")}, []uint32{701}, "SELECT -1.5e3"},
+		{"SELECT $1", []*string{texto("NaN")}, []uint32{701}, "SELECT 'NaN'::DOUBLE"},
+		{"SELECT $1", []*string{texto("-Infinity")}, []uint32{700}, "SELECT '-Infinity'::DOUBLE"},
+		{"SELECT $1", []*string{texto("0x1p-2")}, []uint32{701}, "SELECT '0x1p-2'::DOUBLE"},
+	}
+	for _, c := range casos {
+		obtenido, err := sustituirParametros(c.query, c.valores, c.tipos)
+		if err != nil || obtenido != c.esperado {
+			t.Errorf("sustituirParametros(%q) = %q, %v; se esperaba %q", c.query, obtenido, err, c.esperado)
+		}
+	}
+	if _, err := sustituirParametros("SELECT $2", []*string{texto("a")}, nil); err == nil {
+		t.Error("un parámetro sin valor debería fallar")
+	}
+	if n := contarParametros("SELECT $3, '$9', $1"); n != 3 {
+		t.Errorf("contarParametros = %d, se esperaba 3", n)
+	}
+}
+
+func TestParametroPG(t *testing.T) {
+	casos := []struct {
+		dato     []byte
+		formato  int16
+		oid      uint32
+		esperado string
+	}{
+		{[]byte("42"), 0, 23, "42"},
+		{binary.BigEndian.AppendUint32(nil, uint32(0xffffffff)), 1, 23, "-1"},
+		{binary.BigEndian.AppendUint64(nil, 1<<40), 1, 20, "1099511627776"},
+		{[]byte{1}, 1, 16, "true"},
+		{[]byte("hola"), 1, 25, "hola"},
+	}
+	for _, c := range casos {
+		if obtenido, err := parametroPG(c.dato, c.formato, c.oid); err != nil || obtenido != c.esperado {
+			t.Errorf("parametroPG(%v, %d, %d) = %q, %v; se esperaba %q", c.dato, c.formato, c.oid, obtenido, err, c.esperado)
+		}
+	}
+	if _, err := parametroPG([]byte{1, 2, 3}, 1, 23); err == nil {
+		t.Error("un entero binario de 3 bytes debería fallar")
+	}
+}
+
+func TestLectorPG(t *testing.T) {
+	cuerpo := append([]byte("portal\x00sentencia\x00"), 0, 2, 0, 0, 0, 9)
+	l := &lectorPG{b: cuerpo}
+	if l.cadena() != "portal" || l.cadena() != "sentencia" || l.entero16() != 2 || l.entero32() != 9 || l.err != nil {
+		t.Fatal("lectura inesperada del mensaje")
+	}
+	l.entero16()
+	if l.err == nil {
+		t.Error("leer más allá del mensaje debería fallar")
+	}
+	l = &lectorPG{b: []byte("sin terminar")}
+	if l.cadena(); l.err == nil {
+		t.Error("una cadena sin terminador debería fallar")
+	}
+
+	for _, c := range []struct {
+		cuerpo []byte
+		tam    int
+		n      int
+		ok     bool
+	}{
+		{[]byte{0, 2, 0, 0, 0, 0}, 2, 2, true},
+		{[]byte{0, 2, 0, 0, 0, 0}, 4, 0, false},
+		{[]byte{0xff, 0xfe}, 4, 0, false},
+		{[]byte{0x7f, 0xff}, 2, 0, false},
+	} {
+		l := &lectorPG{b: c.cuerpo}
+		if n := l.cantidad(c.tam); n != c.n || (l.err == nil) != c.ok {
+			t.Errorf("cantidad(%v, %d) = %d, %v", c.cuerpo, c.tam, n, l.err)
+		}
+	}
+}
+
+// Un largo de lista negativo o que no cabe en el mensaje se responde con un
+// error de protocolo, sin reservar memoria por él.
+func TestParseBindLargosInvalidos(t *testing.T) {
+	var salida bytes.Buffer
+	s := &sesionPG{
+		w:          bufio.NewWriter(&salida),
+		sentencias: map[string]*sentenciaPG{"": {query: "SELECT $1"}},
+		portales:   map[string]*portalPG{},
+	}
+	mensajes := []struct {
+		nombre string
+		enviar func([]byte) error
+		cuerpo []byte
+	}{
+		{"Parse negativo", s.parse, append([]byte("p\x00SELECT 1\x00"), 0xff, 0xfe)},
+		{"Parse largo", s.parse, append([]byte("p\x00SELECT 1\x00"), 0x7f, 0xff)},
+		{"Bind formatos", s.bind, []byte{0, 0, 0xff, 0xff}},
+		{"Bind valores", s.bind, []byte{0, 0, 0, 0, 0x80, 0}},
+		{"Bind resultados", s.bind, []byte{0, 0, 0, 0, 0, 0, 0xff, 0xff}},
+	}
+	for _, m := range mensajes {
+		salida.Reset()
+		s.fallida = false
+		if err := m.enviar(m.cuerpo); err != nil {
+			t.Fatal(err)
+		}
+		s.w.Flush()
+		if !s.fallida || !bytes.Contains(salida.Bytes(), []byte("08P01")) {
+			t.Errorf("%s: se esperaba un error de protocolo, llegó %q", m.nombre, salida.Bytes())
+		}
+	}
+}